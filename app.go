@@ -0,0 +1,434 @@
+package goui
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// App hosts the windows of an application.
+// All windows share one HTTP server on the loopback device, one auth cookie
+// and the remote object. Each window has its own websocket connection and model.
+type App struct {
+	origin string
+	cookie string
+	// Incoming http requests are first checked for cookies etc. and
+	// then forwarded to this mux.
+	mux        *http.ServeMux
+	server     *httptest.Server
+	end        chan bool
+	closeOnce  sync.Once
+	remote     interface{}
+	dispatcher *Dispatcher
+	launcher   Launcher
+	log        Logger
+	lock       sync.Mutex
+	windows    map[int]*Window
+	// established is true after the first window has loaded its initial page.
+	established bool
+	// serialTail is closed when the last function passed to serialize has returned
+	serialTail chan struct{}
+}
+
+// DefaultLogger is used by new apps and windows.
+// It writes warnings and errors to os.Stderr.
+var DefaultLogger = NewLogger(os.Stderr, LevelWarn)
+
+// windowParam is the query parameter that tells the browser which window it is showing.
+const windowParam = "goui_window"
+
+// NewApp creates a new HTTP server which can host multiple windows.
+// The functions of the `remote` interface can be called from JavaScript in all windows.
+// Call OpenWindow to launch the UI in the browser.
+func NewApp(remote interface{}) *App {
+	// Make sure the server emits the right Content-Type header
+	mime.AddExtensionType(".css", "text/css")
+
+	// The browser will exchange the token with a cookie
+	cookie := make([]byte, 32)
+	n, err := rand.Reader.Read(cookie)
+	if n != len(cookie) || err != nil {
+		panic("No randomness here")
+	}
+
+	a := &App{
+		mux:        http.NewServeMux(),
+		cookie:     hex.EncodeToString(cookie),
+		end:        make(chan bool),
+		remote:     remote,
+		dispatcher: NewDispatcher(remote),
+		launcher:   DefaultLauncher,
+		log:        DefaultLogger,
+		windows:    make(map[int]*Window),
+		serialTail: make(chan struct{}),
+	}
+	close(a.serialTail)
+
+	ws := &websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error { return a.handshake(config, r) },
+		Handler:   func(conn *websocket.Conn) { a.wshandler(conn) },
+	}
+	// WebSocket
+	a.mux.Handle("/_socket", ws)
+	// JavaScript code for RPC, events, model etc.
+	a.mux.HandleFunc("/_rpc.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		w.Write([]byte(GenerateJSCode(a.remote)))
+	})
+	// The same as ES module
+	a.mux.HandleFunc("/_rpc.mjs", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript")
+		w.Write([]byte(GenerateJSModule(a.remote)))
+	})
+	return a
+}
+
+// Handle registers the handler for the given pattern.
+// The handler serves the content of all windows.
+func (a *App) Handle(pattern string, handler http.Handler) {
+	a.mux.Handle(pattern, handler)
+}
+
+// SetLogger configures the Logger that receives the log output of the app and its windows.
+// Messages that relate to a window carry the attribute `window` and, if they relate to a
+// websocket connection, the attribute `conn`.
+// By default, the app uses DefaultLogger.
+// Use NopLogger to silence the app.
+func (a *App) SetLogger(logger Logger) {
+	a.log = logger
+}
+
+// SetLauncher configures how OpenWindow opens the UI.
+// By default, DefaultLauncher opens the operating system's default browser.
+func (a *App) SetLauncher(launcher Launcher) {
+	a.launcher = launcher
+}
+
+// OpenWindow creates a new window and launches it.
+// The browser will open the `initialPath`, e.g. "/".
+// The `model` is synced to the browser window, i.e. all changes made in Go are synced to the browser.
+// Windows must not share models.
+// OpenWindow returns after the window has connected or if the window could not be started.
+// OpenWindow can be called at any time, e.g. to open additional windows from a remote method.
+func (a *App) OpenWindow(initialPath string, model ModelIface) (*Window, error) {
+	w := a.newWindow(initialPath, model)
+	return w, a.open(w)
+}
+
+// Wait blocks until the user closed all windows of the app.
+func (a *App) Wait() {
+	<-a.end
+}
+
+func (a *App) newWindow(initialPath string, model ModelIface) *Window {
+	// Create a token that is passed in the URL to the browser
+	token := make([]byte, 32)
+	n, err := rand.Reader.Read(token)
+	if n != len(token) || err != nil {
+		panic("No randomness here")
+	}
+
+	s := &Window{
+		app:         a,
+		id:          int(atomic.AddInt32(&windowCounter, 1)),
+		token:       hex.EncodeToString(token),
+		end:         make(chan bool),
+		connected:   make(chan bool),
+		model:       model,
+		initalPath:  initialPath,
+		syncTrigger: make(chan struct{}, 1),
+	}
+	s.notifier = &syncNotifier{window: s}
+	return s
+}
+
+// listen starts the HTTP server unless it is already running.
+func (a *App) listen() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.server != nil {
+		return
+	}
+	// Start accepting incoming HTTP requests
+	a.server = httptest.NewServer(a)
+	a.origin = a.server.URL
+	a.log.Info("listening", "url", a.server.URL)
+}
+
+// open launches the browser for the window and waits for the window to connect.
+func (a *App) open(s *Window) error {
+	a.listen()
+
+	a.lock.Lock()
+	a.windows[s.id] = s
+	a.lock.Unlock()
+
+	// Launch the browser
+	u := a.server.URL + "/?token=" + s.token
+	err := a.launcher.Launch(u)
+	if err != nil {
+		s.close()
+		return err
+	}
+
+	// Wait for someone to connect in time.
+	// Otherwise close
+	timeout := connectTimeout
+	if t, ok := a.launcher.(TimeoutLauncher); ok {
+		timeout = t.ConnectTimeout()
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case <-s.connected:
+		//            println("UI has connected")
+		// Ok, do nothing
+	case <-expired:
+		// Terminate the window, because the UI did not come up
+		s.close()
+		return errors.New("UI could not be started")
+	case <-s.end:
+		// The window has been closed before a timeout and before
+		// a successfull connect happened
+		return errors.New("UI could not be started")
+	}
+	return nil
+}
+
+// ServeHTTP handles incoming HTTP requests, checks authentication via the auth cookie
+// and checks for CSRF attacks via Referer and Origin header fields.
+// Do not call this function from the application code.
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.log.Debug("request", "url", r.URL.String(), "remote", r.RemoteAddr)
+	v := r.URL.Query()
+	a.lock.Lock()
+	// Find the window which waits for its initial page, if any
+	var landing *Window
+	if r.URL.Path == "/" && v.Get("token") != "" {
+		for _, s := range a.windows {
+			if s.token != "" && v.Get("token") == s.token {
+				landing = s
+				break
+			}
+		}
+		if landing != nil {
+			a.lock.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: "secret", Value: a.cookie})
+			// http.Redirect(w, r, "/foo", http.StatusSeeOther)
+			// Redirect the browser to the initial page and serve an auth cookie.
+			// On linux, xdg-open unfortunately requests the page itself and follows redirections.
+			// Thus, HTTP redirect will not do as it redirects xdg-open instead of the browser.
+			// The window ID in the URL tells the JavaScript code which websocket to open.
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(fmt.Sprintf("<html><head><meta http-equiv=\"refresh\" content=\"0; url=%v\"></head><body></body></html>", html.EscapeString(landing.initialURL()))))
+			return
+		}
+	}
+	if id, err := strconv.Atoi(v.Get(windowParam)); err == nil {
+		if s, ok := a.windows[id]; ok && s.token != "" && r.URL.Path == s.initialPathOnly() {
+			landing = s
+		}
+	}
+	if landing != nil {
+		// The browser hit the landing page. From now on, do not hand out the cookie for this window any more.
+		landing.token = ""
+		a.established = true
+		a.lock.Unlock()
+	} else if !a.established {
+		a.lock.Unlock()
+		if r.URL.Path != "/favicon.ico" {
+			// println("Wrong initial request: ", r.URL.String())
+			// This is the wrong request. Must not happen before the initial page has been requested.
+			// Might be an attack. Stop the process.
+			a.log.Error("unexpected initial request, closing app", "url", r.URL.String())
+			a.close()
+			return
+		}
+	} else {
+		a.lock.Unlock()
+		// println("Normal request ...", r.Method)
+		// println(r.RemoteAddr)
+
+		// CSRF prevention. Referer or Origin must be present and correct
+		referer := r.Header.Get("Referer")
+		origin := r.Header.Get("Origin")
+		if origin != a.origin && !strings.HasPrefix(referer, a.origin+"/") {
+			a.log.Warn("wrong referer and origin", "url", r.URL.String(), "referer", referer, "origin", origin)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Authentication via cookie
+	c, err := r.Cookie("secret")
+	if c == nil || err != nil || c.Value != a.cookie {
+		a.log.Warn("missing cookie", "url", r.URL.String())
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Now deliver content as requested
+	a.mux.ServeHTTP(w, r)
+}
+
+// serialize runs fn in a new goroutine after all functions passed to serialize before have returned.
+// Remote methods without a context.Context and the modifications of the models by the
+// browsers are serialized per app, because the remote object is shared by all windows.
+func (a *App) serialize(fn func()) {
+	a.lock.Lock()
+	prev := a.serialTail
+	done := make(chan struct{})
+	a.serialTail = done
+	a.lock.Unlock()
+	go func() {
+		defer close(done)
+		<-prev
+		fn()
+	}()
+}
+
+// close terminates all windows and stops the HTTP server.
+func (a *App) close() {
+	a.closeOnce.Do(func() {
+		a.lock.Lock()
+		windows := a.windows
+		a.windows = make(map[int]*Window)
+		a.lock.Unlock()
+		for _, s := range windows {
+			s.terminate()
+		}
+		if a.server != nil {
+			// Close asynchronously, because close might be called by a request handler
+			// and the server waits for all requests to complete.
+			go func() {
+				a.server.CloseClientConnections()
+				a.server.Close()
+			}()
+		}
+		close(a.end)
+	})
+}
+
+// windowClosed removes the window from the app.
+// The app terminates when its last window has been closed.
+func (a *App) windowClosed(s *Window) {
+	a.lock.Lock()
+	delete(a.windows, s.id)
+	last := len(a.windows) == 0
+	a.lock.Unlock()
+	if last {
+		a.close()
+	}
+}
+
+// socketWindow returns the window that is addressed by a websocket request.
+func (a *App) socketWindow(r *http.Request) *Window {
+	id, err := strconv.Atoi(r.URL.Query().Get("window"))
+	if err != nil {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.windows[id]
+}
+
+// Accept incoming websockets and allow for RPC
+func (a *App) handshake(config *websocket.Config, r *http.Request) error {
+	c, err := r.Cookie("secret")
+	if c == nil || err != nil || c.Value != a.cookie {
+		a.log.Warn("illegal websocket connect")
+		return errors.New("Unauthorized")
+	}
+	// CSRF prevention
+	origin := r.Header.Get("Origin")
+	if origin != a.origin {
+		a.log.Warn("wrong websocket origin", "origin", origin)
+		return errors.New("Unauthorized")
+	}
+	s := a.socketWindow(r)
+	if s == nil {
+		a.log.Warn("websocket connect for unknown window", "url", r.URL.String())
+		return errors.New("Unauthorized")
+	}
+	return s.handshake(config)
+}
+
+func (a *App) wshandler(conn *websocket.Conn) {
+	s := a.socketWindow(conn.Request())
+	if s == nil {
+		// The window has been closed in the meantime
+		conn.Close()
+		return
+	}
+	s.wshandler(conn)
+}
+
+// syncModels synchronizes the models of all windows.
+func (a *App) syncModels() {
+	a.lock.Lock()
+	windows := make([]*Window, 0, len(a.windows))
+	for _, s := range a.windows {
+		windows = append(windows, s)
+	}
+	a.lock.Unlock()
+	for _, s := range windows {
+		s.SyncModel()
+	}
+}
+
+// lockModels locks the models of all windows and returns a function which unlocks them.
+// A remote method without a context.Context may modify the model of each window,
+// because the remote object is shared by all windows. The models are locked in the
+// order of the window IDs, hence concurrent calls of lockModels cannot deadlock.
+func (a *App) lockModels() func() {
+	a.lock.Lock()
+	windows := make([]*Window, 0, len(a.windows))
+	for _, s := range a.windows {
+		windows = append(windows, s)
+	}
+	a.lock.Unlock()
+	sort.Slice(windows, func(i, j int) bool { return windows[i].id < windows[j].id })
+	for _, s := range windows {
+		s.modelLock.Lock()
+	}
+	return func() {
+		for _, s := range windows {
+			s.modelLock.Unlock()
+		}
+	}
+}
+
+// initialURL returns the URL of the initial page including the window ID.
+func (s *Window) initialURL() string {
+	sep := "?"
+	if strings.Contains(s.initalPath, "?") {
+		sep = "&"
+	}
+	return s.app.server.URL + s.initalPath + sep + windowParam + "=" + strconv.Itoa(s.id)
+}
+
+// initialPathOnly returns the initial path without query.
+func (s *Window) initialPathOnly() string {
+	u, err := url.Parse(s.initalPath)
+	if err != nil {
+		return s.initalPath
+	}
+	return u.Path
+}
@@ -0,0 +1,33 @@
+package goui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrongOrigin(t *testing.T) {
+	a := NewApp(&tsRemote{})
+	a.SetLogger(NopLogger)
+	a.origin = "http://127.0.0.1:1234"
+	a.established = true
+	served := false
+	a.Handle("/data", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	serve := func(origin string) int {
+		r := httptest.NewRequest("GET", a.origin+"/data", nil)
+		r.Header.Set("Origin", origin)
+		r.AddCookie(&http.Cookie{Name: "secret", Value: a.cookie})
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := serve("http://evil.example.com"); code != http.StatusUnauthorized || served {
+		t.Fatalf("Expected %v without serving, got %v", http.StatusUnauthorized, code)
+	}
+	if code := serve(a.origin); code != http.StatusOK || !served {
+		t.Fatalf("Expected %v, got %v", http.StatusOK, code)
+	}
+}
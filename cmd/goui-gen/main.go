@@ -0,0 +1,106 @@
+// Command goui-gen writes the JavaScript API of a goui window or its TypeScript declarations to a file,
+// such that they can be bundled with the application code.
+//
+// Usage, from within the module of the application:
+//
+//	go run github.com/weistn/goui/cmd/goui-gen -format ts -remote example.com/app.WindowAPI -model example.com/app.AppModel -o goui.d.ts
+//	go run github.com/weistn/goui/cmd/goui-gen -format mjs -remote example.com/app.WindowAPI -o goui.mjs
+//
+// The format is one of
+//
+//	js   the script served as /_rpc.js, which assigns window.go
+//	mjs  the ES module served as /_rpc.mjs
+//	ts   TypeScript declarations for the API and the model
+//
+// Types are given as import path and type name. The model is only used by the format ts.
+// Since Go cannot load types at runtime, goui-gen generates a small program which
+// calls goui.GenerateTypeScript and runs it with `go run`.
+// Thus, the packages must be importable, i.e. the remote and model types must not live in package main.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	format := flag.String("format", "ts", "js, mjs or ts")
+	remote := flag.String("remote", "", "import path and name of the remote type, e.g. example.com/app.WindowAPI")
+	model := flag.String("model", "", "import path and name of the model type, e.g. example.com/app.AppModel")
+	out := flag.String("o", "", "output file. The default is stdout")
+	flag.Parse()
+	if *remote == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	code, err := generate(*format, *remote, *model)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *out == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := os.WriteFile(*out, code, 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// splitType splits "example.com/app.WindowAPI" into import path and type name.
+func splitType(s string) (string, string, error) {
+	i := strings.LastIndex(s, ".")
+	if i <= 0 || i < strings.LastIndex(s, "/") {
+		return "", "", fmt.Errorf("malformed type %q, expected importpath.Type", s)
+	}
+	return s[:i], s[i+1:], nil
+}
+
+// generate writes a program to a temporary directory in the current module and runs it.
+func generate(format string, remote string, model string) ([]byte, error) {
+	rpath, rname, err := splitType(remote)
+	if err != nil {
+		return nil, err
+	}
+	imports := fmt.Sprintf("\tr %q\n", rpath)
+	rexpr := fmt.Sprintf("(*r.%v)(nil)", rname)
+	mexpr := "nil"
+	if model != "" && format == "ts" {
+		mpath, mname, err := splitType(model)
+		if err != nil {
+			return nil, err
+		}
+		imports += fmt.Sprintf("\tm %q\n", mpath)
+		mexpr = fmt.Sprintf("(*m.%v)(nil)", mname)
+	}
+	var call string
+	switch format {
+	case "js":
+		call = fmt.Sprintf("goui.GenerateJSCode(%v)", rexpr)
+	case "mjs":
+		call = fmt.Sprintf("goui.GenerateJSModule(%v)", rexpr)
+	case "ts":
+		call = fmt.Sprintf("goui.GenerateTypeScript(%v, %v)", rexpr, mexpr)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	src := fmt.Sprintf("package main\n\nimport (\n\t\"os\"\n\n\t\"github.com/weistn/goui\"\n%v)\n\nfunc main() {\n\tos.Stdout.WriteString(%v)\n}\n", imports, call)
+
+	// The directory name starts with an underscore, so `go build ./...` ignores it
+	dir, err := os.MkdirTemp(".", "_goui-gen")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(src), 0644); err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("go", "run", "./"+filepath.Base(dir))
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
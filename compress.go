@@ -0,0 +1,85 @@
+package goui
+
+import (
+	"bytes"
+	"compress/zlib"
+
+	"golang.org/x/net/websocket"
+)
+
+// compressedFlag is the first byte of a binary message that contains a zlib compressed message.
+// Uncompressed binary messages are MessagePack maps and never start with this byte.
+const compressedFlag = 0
+
+// CompressionStats reports the effect of compressing the messages of a window.
+type CompressionStats struct {
+	// Messages is the number of compressed messages.
+	Messages int
+	// Original is the size of the compressed messages before compression in bytes.
+	Original int64
+	// Compressed is the size of the compressed messages in bytes.
+	Compressed int64
+}
+
+// Saved returns the number of bytes saved by compression.
+func (c CompressionStats) Saved() int64 {
+	return c.Original - c.Compressed
+}
+
+// SetCompression enables the compression of messages sent to the browser, e.g. the
+// initial model, which are larger than `threshold` bytes. Messages are compressed
+// with zlib only if the browser supports decompressing them and if this makes them smaller.
+// Compression pays off if the browser is connected via a slow network, e.g. a remote desktop.
+// A threshold of zero disables compression, which is the default.
+func (s *Window) SetCompression(threshold int) {
+	s.lock.Lock()
+	s.compressThreshold = threshold
+	s.lock.Unlock()
+}
+
+// CompressionStats returns the statistics of the messages compressed so far.
+func (s *Window) CompressionStats() CompressionStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.compressStats
+}
+
+// acceptsCompression returns true if the browser can decompress messages.
+func acceptsCompression(conn *websocket.Conn) bool {
+	return conn.Request() != nil && conn.Request().URL.Query().Get("compress") == "1"
+}
+
+// send sends the JSON message `data` on `conn` in the encoding of the connection
+// and compresses it if it is large.
+// `diff` is true if `data` has been encoded for the connection by marshalDiff or fetch,
+// i.e. its byte slices become binary data.
+// The caller must hold the lock of the window.
+func (s *Window) send(conn *websocket.Conn, data []byte, diff bool) error {
+	binary := isBinary(conn)
+	if binary {
+		var err error
+		if data, err = jsonToMsgPack(data, diff); err != nil {
+			return err
+		}
+	}
+	if s.compressThreshold > 0 && len(data) > s.compressThreshold && acceptsCompression(conn) {
+		var b bytes.Buffer
+		b.WriteByte(compressedFlag)
+		w := zlib.NewWriter(&b)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if b.Len() < len(data) {
+			s.compressStats.Messages++
+			s.compressStats.Original += int64(len(data))
+			s.compressStats.Compressed += int64(b.Len())
+			s.log().Debug("compressed message", "conn", s.connID, "size", len(data), "compressed", b.Len())
+			return websocket.Message.Send(conn, b.Bytes())
+		}
+	}
+	if binary {
+		return websocket.Message.Send(conn, data)
+	}
+	return websocket.Message.Send(conn, string(data))
+}
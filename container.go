@@ -0,0 +1,138 @@
+package goui
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// A container is a slice or array which contains models, either directly or
+// nested in further slices or arrays, e.g. []*M, [4]M or [][]*M.
+// Each container is identified by its model and a path. The path of a container
+// stored in a field is the field itself. The path of a nested container is
+// derived from the path of the enclosing container and the index of the nested
+// container, see bookkeeping.elemPath. Models stored in a container use the path of the
+// container as their field.
+
+// isModelPtrType returns true if values of type t are pointers to models.
+func isModelPtrType(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t.Name() == "" && t.Implements(modelIfaceType)
+}
+
+// isModelType returns true if values of type t are model structs.
+func isModelType(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && reflect.PtrTo(t).Implements(modelIfaceType)
+}
+
+// containsModels returns true if values of type t are containers.
+func containsModels(t reflect.Type) bool {
+	if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+		return false
+	}
+	elem := t.Elem()
+	return isModelPtrType(elem) || isModelType(elem) || containsModels(elem)
+}
+
+// modelField resolves the value `fv` of the field `f` of a model.
+// If the field is an interface, the type of the value it holds decides
+// whether the field is a model pointer or a container.
+func modelField(f *Field, fv reflect.Value) (v reflect.Value, isModelPtr bool, isContainer bool) {
+	if fv.Kind() != reflect.Interface || fv.IsNil() {
+		return fv, f.isModelPtr, f.isModelContainer
+	}
+	v = fv.Elem()
+	return v, isModelPtrType(v.Type()), containsModels(v.Type())
+}
+
+type elemPathKey struct {
+	path  *Field
+	index int
+}
+
+// elemPath returns the path of the container which is stored at position `index`
+// of the container with path `path`. The paths are kept by the model which holds
+// the containers, such that a path is the same in each sync.
+func (b *bookkeeping) elemPath(path *Field, index int) *Field {
+	if b == nil {
+		return &Field{name: path.name + "[" + strconv.Itoa(index) + "]"}
+	}
+	key := elemPathKey{path, index}
+	if p, ok := b.paths[key]; ok {
+		return p
+	}
+	p := &Field{name: path.name + "[" + strconv.Itoa(index) + "]"}
+	if b.paths == nil {
+		b.paths = make(map[elemPathKey]*Field)
+	}
+	b.paths[key] = p
+	return p
+}
+
+// containerRecord records a container as it has been synced.
+type containerRecord struct {
+	// null is true if the container has been synced as null
+	null bool
+	// n is the length of the container
+	n int
+	// ids are the IDs of the models stored in a container of models, where -1
+	// stands for a nil pointer. They are nil for a container of containers.
+	ids []int
+}
+
+// swapContainer returns the container with path `path` as it has been synced the
+// last time and replaces it with `c`. The result is false if the browser does not
+// know the container.
+func (b *bookkeeping) swapContainer(path *Field, c containerRecord) (containerRecord, bool) {
+	if b == nil {
+		return containerRecord{}, false
+	}
+	old, ok := b.containers[path]
+	if b.containers == nil {
+		b.containers = make(map[*Field]containerRecord)
+	}
+	b.containers[path] = c
+	return old, ok
+}
+
+// forgetContainer removes the record of the container with path `path`.
+func (b *bookkeeping) forgetContainer(path *Field) {
+	if b != nil {
+		delete(b.containers, path)
+	}
+}
+
+// syncInterfaceModel records that the model `cm` with synchronization state `state`
+// is stored in the interface field `f` of model `m` and returns the state of `cm`.
+// The interface might have held a container or another model in between, which
+// used the same path. If the browser does not hold `cm` in the field, it is sent as a new model.
+func syncInterfaceModel(m ModelIface, f *Field, cm ModelIface, state ModelState) ModelState {
+	books := booksOf(m)
+	books.forgetContainer(f)
+	if id, ok := books.swapModel(f, cm.ModelID()); state != ModelNew && (!ok || id != cm.ModelID()) {
+		cm.ModelTestSync(nil, nil)
+		state = cm.ModelTestSync(m, f)
+		books.swapModel(f, cm.ModelID())
+	}
+	return state
+}
+
+// appendContainerModels appends pointers to the models stored in the container `v`
+// and in its nested containers.
+// Model structs can only be tracked if they are addressable.
+func appendContainerModels(models []reflect.Value, v reflect.Value) []reflect.Value {
+	for i := 0; i < v.Len(); i++ {
+		elem := v.Index(i)
+		switch {
+		case isModelPtrType(elem.Type()):
+			if !elem.IsNil() {
+				models = append(models, elem)
+			}
+		case isModelType(elem.Type()):
+			if elem.CanAddr() {
+				models = append(models, elem.Addr())
+			}
+		default:
+			models = appendContainerModels(models, elem)
+		}
+	}
+	return models
+}
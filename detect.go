@@ -0,0 +1,157 @@
+package goui
+
+import (
+	"hash/fnv"
+	"reflect"
+	"strconv"
+)
+
+// DetectChanges compares each model in the tree rooted at `model` with the state
+// it had when DetectChanges has been called the last time.
+// Models with changed fields are marked with ModelDirty.
+// Thus, the application does not need to call ModelDirty after modifying a model.
+// Each model remembers a hash of its fields, which is cheaper than remembering a copy.
+// Fields of child models are not part of the hash of their parent, hence only those
+// models are synced which actually changed.
+//
+// Use Window.SetChangeDetection to call DetectChanges automatically before a window syncs its model.
+// An error is returned if the model cannot be encoded.
+func DetectChanges(model ModelIface) (err error) {
+	if model == nil {
+		return nil
+	}
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if je, ok := r.(jsonError); ok {
+				err = je.error
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	detectChanges(model, v.Elem())
+	return nil
+}
+
+// detectChanges computes the hash of the model `m` whose struct is `v`
+// and recurses into the child models.
+func detectChanges(m ModelIface, v reflect.Value) {
+	h := fnv.New64a()
+	e := newEncodeState()
+	defer encodeStatePool.Put(e)
+	dirty := false
+	child := func(c ModelIface, cv reflect.Value) {
+		// The address identifies the child, hence replacing, adding and removing
+		// children changes the hash of the parent.
+		e.WriteString(strconv.FormatUint(uint64(cv.Addr().Pointer()), 16))
+		e.WriteByte(',')
+		if c.ModelState() == ModelNew {
+			// The child has never been synced, which means that it has been added.
+			dirty = true
+		}
+		detectChanges(c, cv)
+	}
+	var container func(cv reflect.Value)
+	container = func(cv reflect.Value) {
+		if cv.Kind() == reflect.Slice && cv.IsNil() {
+			e.WriteString("null")
+			return
+		}
+		e.WriteByte('[')
+		for i := 0; i < cv.Len(); i++ {
+			elem := cv.Index(i)
+			switch {
+			case isModelPtrType(elem.Type()):
+				if elem.IsNil() {
+					e.WriteString("null,")
+				} else {
+					child(elem.Interface().(ModelIface), elem.Elem())
+				}
+			case isModelType(elem.Type()) && elem.CanAddr():
+				c := elem.Addr().Interface().(ModelIface)
+				// Models are moved inside the slice by copying them.
+				// Hence, the address does not identify them.
+				// Compare with the index the model has been synced at.
+				if index := c.ModelSwapIndex(i); index != i {
+					c.ModelSwapIndex(index)
+					dirty = true
+				}
+				child(c, elem)
+			case isModelType(elem.Type()):
+				// The model cannot be tracked and is sent as a plain value
+				typeEncoder(elem.Type())(e, elem, encOpts{})
+				e.WriteByte(',')
+			default:
+				container(elem)
+				e.WriteByte(',')
+			}
+		}
+		e.WriteByte(']')
+	}
+
+FieldLoop:
+	for i := range cachedTypeFields(v.Type()).list {
+		f := &cachedTypeFields(v.Type()).list[i]
+		if f.lazy {
+			// Lazy fields are not synced
+			continue
+		}
+
+		// Find the nested struct field by following f.index.
+		fv := v
+		for _, i := range f.index {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue FieldLoop
+				}
+				fv = fv.Elem()
+			}
+			fv = fv.Field(i)
+		}
+
+		e.WriteString(f.nameNonEsc)
+		mv, isModelPtr, isContainer := modelField(f, fv)
+		switch {
+		case isModelPtr:
+			if mv.IsNil() {
+				e.WriteString("null")
+			} else {
+				child(mv.Interface().(ModelIface), mv.Elem())
+			}
+		case f.isModel && fv.CanAddr():
+			child(fv.Addr().Interface().(ModelIface), fv)
+		case isContainer:
+			container(mv)
+		case f.isModelMapPtr:
+			if fv.IsNil() {
+				e.WriteString("null")
+				break
+			}
+			e.WriteByte('{')
+			for _, kv := range sortedMapKeys(e, fv) {
+				e.string(kv.s, false)
+				e.WriteByte(':')
+				elem := fv.MapIndex(kv.v)
+				if elem.IsNil() {
+					e.WriteString("null,")
+					continue
+				}
+				child(elem.Interface().(ModelIface), elem.Elem())
+			}
+			e.WriteByte('}')
+		default:
+			opts := encOpts{quoted: f.quoted}
+			f.encoder(e, fv, opts)
+		}
+		e.WriteByte(',')
+	}
+
+	h.Write(e.Bytes())
+	if booksOf(m).hashChanged(h.Sum64()) || dirty {
+		m.ModelDirty()
+	}
+}
@@ -0,0 +1,52 @@
+package goui
+
+import "testing"
+
+func TestDetectChanges(t *testing.T) {
+	m := &MyModel{Age: 42, Details: &DetailsModel{Name: "Joe"}, List: []*DetailsModel{{Name: "Elem 0"}}}
+	m.Embed.More.Name = "More"
+
+	sync := func(expected string, models ...ModelIface) {
+		t.Helper()
+		if err := DetectChanges(m); err != nil {
+			t.Fatal(err)
+		}
+		checkDiff(t, m, expected, models...)
+	}
+
+	if err := DetectChanges(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MarshalDiff(m); err != nil {
+		t.Fatal(err)
+	}
+	sync(`{"m":null}`)
+
+	m.Age = 43
+	sync(`{"m":{"Age":43,"Details2":null}}`)
+
+	m.Details.Name = "Jack"
+	m.Embed.More.Name = "Less"
+	sync(`{"m":{"Details":{"Name":"Jack"},"Embed":{"More":{"Name":"Less"}}}}`)
+
+	m.List[0].Name = "Changed"
+	sync(`{"m":{"List":{"_a":[0,{"Name":"Changed"}],"_l":1}}}`)
+
+	// Swapping children changes the parent
+	m.Details, m.Details2 = nil, m.Details
+	sync(`{"m":{"Age":43,"Details":null,"Details2":{"_id":%v,"Name":"Jack"}}}`, m.Details2)
+}
+
+func TestDetectMoves(t *testing.T) {
+	m := &List2Model{List: []DetailsModel{{Name: "Elem 0"}, {Name: "Elem 1"}}}
+	DetectChanges(m)
+	MarshalDiff(m)
+
+	m.List[0], m.List[1] = m.List[1], m.List[0]
+	if err := DetectChanges(m); err != nil {
+		t.Fatal(err)
+	}
+	if m.ModelState() == ModelSynced {
+		t.Fatal("Moving elements must be detected")
+	}
+}
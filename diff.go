@@ -22,23 +22,37 @@ import (
 	"unicode/utf8"
 )
 
-// MarshalDiff marshals
+// MarshalDiff marshals the changes of v since the last call.
+// The sync state and the model IDs are stored in the models themselves, hence
+// a model tree can be diffed for one consumer only. Use a Differ for several consumers.
 func MarshalDiff(v interface{}) ([]byte, error) {
+	return marshalDiff(v, nil, false, nil)
+}
+
+// marshalDiff marshals v. If v is a model, `parent` becomes its parent.
+// If `binary` is true, byte slices are encoded for transcoding to MessagePack.
+// If `d` is not nil, it keeps the sync state of the models.
+func marshalDiff(v interface{}, parent ModelIface, binary bool, d *Differ) ([]byte, error) {
 	opts := encOpts{escapeHTML: true}
+	e := newEncodeState()
+	e.binary = binary
+	e.differ = d
 
 	mv, ok := v.(ModelIface)
 	if ok {
+		if mv != nil {
+			mv = e.tracked(mv)
+		}
 		if mv == nil || mv.ModelState() == ModelSynced {
+			encodeStatePool.Put(e)
 			return []byte("{\"m\":null}"), nil
 		}
 		opts.isModel = true
 		opts.model = mv
-		opts.modelState = mv.ModelTestSync(nil, nil)
+		opts.modelState = mv.ModelTestSync(parent, nil)
 		// println(mv.ModelState())
 	}
 
-	e := newEncodeState()
-
 	err := e.marshal(v, opts)
 	if err != nil {
 		return nil, err
@@ -69,6 +83,27 @@ type encodeState struct {
 	// reasonable amount of nested pointers deep.
 	ptrLevel uint
 	ptrSeen  map[interface{}]struct{}
+
+	// binary writes byte slices as b"<base64>", which jsonToMsgPack turns into binary data.
+	binary bool
+	// differ keeps the sync state of the models. If nil, the models keep it themselves.
+	differ *Differ
+}
+
+// tracked returns the object which keeps the sync state of the model `m`.
+func (e *encodeState) tracked(m ModelIface) ModelIface {
+	if e.differ == nil {
+		return m
+	}
+	return e.differ.shadow(m)
+}
+
+// newScratch returns an encodeState which encodes like `e`.
+func (e *encodeState) newScratch() *encodeState {
+	scratch := newEncodeState()
+	scratch.binary = e.binary
+	scratch.differ = e.differ
+	return scratch
 }
 
 const startDetectingCyclesAfter = 1000
@@ -83,6 +118,8 @@ func newEncodeState() *encodeState {
 			panic("ptrEncoder.encode should have emptied ptrSeen via defers")
 		}
 		e.ptrLevel = 0
+		e.binary = false
+		e.differ = nil
 		return e
 	}
 	return &encodeState{ptrSeen: make(map[interface{}]struct{})}
@@ -138,13 +175,16 @@ type encOpts struct {
 	// quoted causes primitive fields to be encoded inside JSON strings.
 	quoted bool
 	// escapeHTML causes '<', '>', and '&' to be escaped in JSON strings.
-	escapeHTML              bool
-	isModel                 bool
-	model                   ModelIface
-	modelState              ModelState
-	fieldIsSliceOfModels    bool
-	fieldIsSliceOfModelPtrs bool
-	field                   *Field
+	escapeHTML            bool
+	isModel               bool
+	model                 ModelIface
+	modelState            ModelState
+	fieldIsContainer      bool
+	fieldIsMap            bool
+	fieldIsMapOfModelPtrs bool
+	field                 *Field
+	// plain is set for a struct which is part of the model, but not a model itself.
+	plain bool
 }
 
 type encoderFunc func(e *encodeState, v reflect.Value, opts encOpts)
@@ -512,14 +552,25 @@ type structFields struct {
 func (se structEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 	next := byte('{')
 
-	if opts.isModel && opts.modelState == ModelNew {
+	if opts.isModel && opts.modelState == ModelNew && !opts.plain {
 		e.WriteString(fmt.Sprintf("{\"_id\":%v", opts.model.ModelID()))
 		next = ','
+		// The browser does not know the model, e.g. because it has been moved
+		// or because of a full resync. Hence, its children must be sent, too.
+		for _, c := range childModels(v) {
+			if cm := e.tracked(c.Interface().(ModelIface)); cm.ModelState() != ModelNew {
+				cm.ModelTestSync(nil, nil)
+			}
+		}
 	}
 
 FieldLoop:
 	for i := range se.fields.list {
 		f := &se.fields.list[i]
+		if f.lazy {
+			// Lazy fields are fetched by the browser on request
+			continue
+		}
 
 		// Find the nested struct field by following f.index.
 		fv := v
@@ -538,18 +589,32 @@ FieldLoop:
 		}
 
 		var fOpts = opts
+		// mv is the value of a container field
+		var mv reflect.Value
 		if opts.isModel {
-			if f.isModelPtr {
+			var isModelPtr, isContainer bool
+			mv, isModelPtr, isContainer = modelField(f, fv)
+			if fv.Kind() == reflect.Interface && !isContainer && (!isModelPtr || mv.IsNil()) {
+				// The browser holds neither a container nor a model in this field
+				books := booksOf(opts.model)
+				books.forgetContainer(f)
+				books.forgetModel(f)
+			}
+			if isModelPtr {
 				// println("Check model field", f.name)
-				if isEmptyValue(fv) {
+				if isEmptyValue(mv) {
 					if opts.modelState == ModelChildDirty {
 						// Only serialize non-nil dirty child models
 						continue FieldLoop
 					}
 				} else {
 					fOpts.isModel = true
-					fOpts.model = fv.Interface().(ModelIface)
+					fOpts.plain = false
+					fOpts.model = e.tracked(mv.Interface().(ModelIface))
 					fOpts.modelState = fOpts.model.ModelTestSync(opts.model, f)
+					if fv.Kind() == reflect.Interface {
+						fOpts.modelState = syncInterfaceModel(opts.model, f, fOpts.model, fOpts.modelState)
+					}
 					if fOpts.modelState == ModelSynced {
 						// Only serialize non-nil dirty child models
 						continue FieldLoop
@@ -560,38 +625,65 @@ FieldLoop:
 				// println("Can address model", f.name)
 				addr := fv.Addr()
 				fOpts.isModel = true
-				fOpts.model = addr.Interface().(ModelIface)
+				fOpts.plain = false
+				fOpts.model = e.tracked(addr.Interface().(ModelIface))
 				fOpts.modelState = fOpts.model.ModelTestSync(opts.model, f)
 				if fOpts.modelState == ModelSynced {
 					// Only serialize non-nil dirty child models
 					continue FieldLoop
 				}
 				// println("Serialize model field", f.name)
-			} else if f.isModelSlicePtr {
-				fOpts.fieldIsSliceOfModelPtrs = true
-				// if isSlicePtrSynced(fv) {
-				// Only serialize non-nil dirty child models
-				// 	continue FieldLoop
-				// }
-				// println("Serialize model slice ptr field", f.name)
-			} else if f.isModelSlice {
-				fOpts.fieldIsSliceOfModels = true
-				// TODO
+			} else if isContainer {
+				if fv.Kind() == reflect.Interface {
+					// The interface might have held a model before
+					booksOf(opts.model).forgetModel(f)
+				}
+				fOpts.fieldIsContainer = true
+				fOpts.field = f
+			} else if f.isModelMapPtr {
+				fOpts.fieldIsMapOfModelPtrs = true
+				fOpts.field = f
 			} else if opts.modelState == ModelChildDirty {
 				// Only serialize non-nil dirty child models
 				continue FieldLoop
+			} else if f.isMap {
+				fOpts.fieldIsMap = true
+				fOpts.field = f
+			} else if fv.Kind() == reflect.Struct {
+				fOpts.plain = true
+			} else if fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct {
+				// The struct is part of the model. If the browser holds null
+				// instead, it receives the entire struct.
+				if !booksOf(opts.model).swapStruct(f, !fv.IsNil()) && !fv.IsNil() {
+					fOpts.modelState = ModelNew
+				}
+				fOpts.plain = true
 			}
 		}
 
+		mark := e.Len()
 		e.WriteByte(next)
-		next = ','
 		if opts.escapeHTML {
 			e.WriteString(f.nameEscHTML)
 		} else {
 			e.WriteString(f.nameNonEsc)
 		}
-		opts.quoted = f.quoted
-		f.encoder(e, fv, fOpts)
+		fOpts.quoted = f.quoted
+		changed := true
+		switch {
+		case fOpts.fieldIsContainer:
+			changed = encodeContainer(e, mv, fOpts)
+		case fOpts.fieldIsMap || fOpts.fieldIsMapOfModelPtrs:
+			changed = mapEncoder{typeEncoder(fv.Type().Elem())}.encodeModelMap(e, fv, fOpts)
+		default:
+			f.encoder(e, fv, fOpts)
+		}
+		if changed {
+			next = ','
+		} else {
+			// The browser has the same container or map already
+			e.Truncate(mark)
+		}
 
 		if fOpts.isModel && fOpts.modelState != ModelSynced {
 			fOpts.model.ModelSynced()
@@ -620,7 +712,173 @@ func (me mapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 	}
 	e.WriteByte('{')
 
-	// Extract and sort the keys.
+	for i, kv := range sortedMapKeys(e, v) {
+		if i > 0 {
+			e.WriteByte(',')
+		}
+		e.string(kv.s, opts.escapeHTML)
+		e.WriteByte(':')
+		me.elemEnc(e, v.MapIndex(kv.v), opts)
+	}
+	e.WriteByte('}')
+}
+
+// encodeModelMap encodes a map which is a field of a model.
+// If the map has been synced before, only the changed entries are encoded:
+//
+//	{"_m":{"k1":diff},"_s":{"k2":value},"_c":{"k3":"k4"},"_r":["k5"]}
+//
+// `_r` lists the removed keys, `_c` copies entries of the previous version of the
+// map to a new key, `_s` sets entries to a new value and `_m` applies a diff to
+// the model stored under a key.
+// Only maps of model pointers are diffed entry by entry. Entries of all other maps
+// are compared with the JSON encoding sent the last time.
+// A map which has not been synced before, e.g. because it has been nil, is sent
+// as a diff, too, which sets all entries. `"_n":1` tells the browser to apply it
+// to a new object.
+// The result is false if the browser has the same map already.
+func (me mapEncoder) encodeModelMap(e *encodeState, v reflect.Value, opts encOpts) bool {
+	// entries is filled below. It records the map as it is sent now.
+	var entries map[string]string
+	if !v.IsNil() {
+		entries = make(map[string]string)
+	}
+	old, ok := booksOf(opts.model).swapMap(opts.field, entries)
+	if opts.modelState == ModelNew {
+		// The browser does not know the model, hence neither its maps
+		ok = false
+	}
+	if v.IsNil() {
+		e.WriteString("null")
+		return !ok || old != nil
+	}
+	if opts.modelState == ModelNew {
+		// Send the entire map
+		e.WriteByte('{')
+		for i, kv := range sortedMapKeys(e, v) {
+			if i > 0 {
+				e.WriteByte(',')
+			}
+			e.string(kv.s, opts.escapeHTML)
+			e.WriteByte(':')
+			start := e.Len()
+			entries[kv.s] = me.encodeMapElem(e, v.MapIndex(kv.v), opts, start)
+		}
+		e.WriteByte('}')
+		return true
+	}
+
+	// The keys of models in the previous version of the map
+	oldKeys := make(map[string]string)
+	if opts.fieldIsMapOfModelPtrs {
+		for key, id := range old {
+			oldKeys[id] = key
+		}
+	}
+	var sets, mods, copies []string
+	scratch := e.newScratch()
+	defer encodeStatePool.Put(scratch)
+	for _, kv := range sortedMapKeys(e, v) {
+		elem := v.MapIndex(kv.v)
+		scratch.Reset()
+		scratch.string(kv.s, opts.escapeHTML)
+		scratch.WriteByte(':')
+		start := scratch.Len()
+		if !opts.fieldIsMapOfModelPtrs || elem.IsNil() {
+			entries[kv.s] = me.encodeMapElem(scratch, elem, opts, start)
+			if o, ok := old[kv.s]; !ok || o != entries[kv.s] {
+				sets = append(sets, scratch.String())
+			}
+			continue
+		}
+		m := e.tracked(elem.Interface().(ModelIface))
+		state := m.ModelTestSync(opts.model, opts.field)
+		id := strconv.Itoa(m.ModelID())
+		oldKey, ok := oldKeys[id]
+		if state != ModelNew && !ok {
+			// The model has been removed from the map and added again.
+			// The browser does not know it any more, hence send it as a new model.
+			m.ModelTestSync(nil, nil)
+			state = m.ModelTestSync(opts.model, opts.field)
+			id = strconv.Itoa(m.ModelID())
+		}
+		entries[kv.s] = id
+		if state == ModelNew {
+			me.encodeMapModel(scratch, elem, m, state, opts)
+			sets = append(sets, scratch.String())
+			continue
+		}
+		if oldKey != kv.s {
+			// The model has been moved to another key
+			scratch.string(oldKey, opts.escapeHTML)
+			copies = append(copies, scratch.String())
+			scratch.Truncate(start)
+		}
+		if state != ModelSynced {
+			me.encodeMapModel(scratch, elem, m, state, opts)
+			mods = append(mods, scratch.String())
+		}
+	}
+
+	e.WriteString("{\"_m\":{")
+	e.WriteString(strings.Join(mods, ","))
+	e.WriteByte('}')
+	if !ok || old == nil {
+		e.WriteString(",\"_n\":1")
+	}
+	if len(sets) > 0 {
+		e.WriteString(",\"_s\":{")
+		e.WriteString(strings.Join(sets, ","))
+		e.WriteByte('}')
+	}
+	if len(copies) > 0 {
+		e.WriteString(",\"_c\":{")
+		e.WriteString(strings.Join(copies, ","))
+		e.WriteByte('}')
+	}
+	var removed []string
+	for key := range old {
+		if _, ok := entries[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	if len(removed) > 0 {
+		sort.Strings(removed)
+		e.WriteString(",\"_r\":[")
+		for i, key := range removed {
+			if i > 0 {
+				e.WriteByte(',')
+			}
+			e.string(key, opts.escapeHTML)
+		}
+		e.WriteByte(']')
+	}
+	e.WriteByte('}')
+	return !ok || old == nil || len(mods)+len(sets)+len(copies)+len(removed) > 0
+}
+
+// encodeMapElem encodes an element of a map which is a field of a model.
+// It returns the value recorded for the element, i.e. the ID of a model or
+// the JSON encoding, which starts at position `start` of e.
+func (me mapEncoder) encodeMapElem(e *encodeState, elem reflect.Value, opts encOpts, start int) string {
+	if !opts.fieldIsMapOfModelPtrs || elem.IsNil() {
+		me.elemEnc(e, elem, encOpts{escapeHTML: opts.escapeHTML})
+		return string(e.Bytes()[start:])
+	}
+	m := e.tracked(elem.Interface().(ModelIface))
+	me.encodeMapModel(e, elem, m, m.ModelTestSync(opts.model, opts.field), opts)
+	return strconv.Itoa(m.ModelID())
+}
+
+// encodeMapModel encodes a model stored in a map.
+func (me mapEncoder) encodeMapModel(e *encodeState, elem reflect.Value, m ModelIface, state ModelState, opts encOpts) {
+	eOpts := encOpts{escapeHTML: opts.escapeHTML, isModel: true, model: m, modelState: state}
+	me.elemEnc(e, elem, eOpts)
+	m.ModelSynced()
+}
+
+// sortedMapKeys returns the keys of a map sorted by their string representation.
+func sortedMapKeys(e *encodeState, v reflect.Value) []reflectWithString {
 	keys := v.MapKeys()
 	sv := make([]reflectWithString, len(keys))
 	for i, v := range keys {
@@ -630,16 +888,7 @@ func (me mapEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 		}
 	}
 	sort.Slice(sv, func(i, j int) bool { return sv[i].s < sv[j].s })
-
-	for i, kv := range sv {
-		if i > 0 {
-			e.WriteByte(',')
-		}
-		e.string(kv.s, opts.escapeHTML)
-		e.WriteByte(':')
-		me.elemEnc(e, v.MapIndex(kv.v), opts)
-	}
-	e.WriteByte('}')
+	return sv
 }
 
 func newMapEncoder(t reflect.Type) encoderFunc {
@@ -662,6 +911,9 @@ func encodeByteSlice(e *encodeState, v reflect.Value, _ encOpts) {
 		return
 	}
 	s := v.Bytes()
+	if e.binary {
+		e.WriteByte('b')
+	}
 	e.WriteByte('"')
 	encodedLen := base64.StdEncoding.EncodedLen(len(s))
 	if encodedLen <= len(e.scratch) {
@@ -717,168 +969,400 @@ type arrayEncoder struct {
 
 func (ae arrayEncoder) encode(e *encodeState, v reflect.Value, opts encOpts) {
 	n := v.Len()
+	e.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			e.WriteByte(',')
+		}
+		ae.elemEnc(e, v.Index(i), opts)
+	}
+	e.WriteByte(']')
+}
 
-	if opts.isModel && opts.modelState != ModelNew && (opts.fieldIsSliceOfModelPtrs || opts.fieldIsSliceOfModels) {
-		e.WriteString("{\"_a\":[0")
-		oldIndex := 0
-		insertCount := 0
-		skipCount := 0
-		copyPos := 0
-		copyCount := 0
-		for i := 0; i < n; i++ {
-			// Get the model at position i in the slice
-			elem := v.Index(i)
-			var eOpts = opts
-			eOpts.fieldIsSliceOfModelPtrs = false
-			eOpts.isModel = true
-			if isEmptyValue(elem) {
-				eOpts.model = nil
-			} else if opts.fieldIsSliceOfModelPtrs {
-				eOpts.model = elem.Interface().(ModelIface)
-			} else if opts.fieldIsSliceOfModels {
-				addr := elem.Addr()
-				eOpts.model = addr.Interface().(ModelIface)
-				// println("SliceOfModels", i, eOpts.model.ModelID())
-			}
+// encodeContainer encodes a slice or array `v` which contains models.
+// It is stored under the path `opts.field` of the model `opts.model`.
+// If the browser knows the container, only the changes are encoded as an array diff:
+//
+//	{"_a":[0,directives...],"_l":length}
+//
+// The models stored in the container are diffed by their position in the previous
+// version of the container. Nested containers are diffed position by position.
+// The result is false if the browser has the same container already.
+func encodeContainer(e *encodeState, v reflect.Value, opts encOpts) bool {
+	books := booksOf(opts.model)
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		e.WriteString("null")
+		old, ok := books.swapContainer(opts.field, containerRecord{null: true})
+		return !ok || !old.null || opts.modelState == ModelNew
+	}
+	n := v.Len()
+	old, ok := books.swapContainer(opts.field, containerRecord{n: n})
+	if opts.modelState == ModelNew || old.null {
+		// The browser does not know the model, hence neither its containers.
+		// A container which has been null is sent entirely, too.
+		ok = false
+	}
+	elemType := v.Type().Elem()
+	switch {
+	case isModelPtrType(elemType), isModelType(elemType) && (n == 0 || v.Index(0).CanAddr()):
+		if !ok || old.ids == nil {
+			encodeModels(e, v, opts)
+			return true
+		}
+		return diffModels(e, v, opts, old.ids)
+	case containsModels(elemType):
+		if !ok || old.ids != nil {
+			encodeContainers(e, v, opts)
+			return true
+		}
+		return diffContainers(e, v, opts, old.n)
+	}
+	// Model structs which are not addressable cannot be tracked, e.g. in an array
+	// which is held by an interface. Encode them as plain values.
+	enc := typeEncoder(elemType)
+	e.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			e.WriteByte(',')
+		}
+		enc(e, v.Index(i), encOpts{escapeHTML: opts.escapeHTML})
+	}
+	e.WriteByte(']')
+	return true
+}
 
-			// Determine where the element is located in the previous version
-			// of the slice.
-			var delCount = 0
-			var index = 0
-			if eOpts.model != nil {
-				eOpts.modelState = eOpts.model.ModelTestSync(opts.model, opts.field)
-				// println("    id:", eOpts.model.ModelID())
-				index = eOpts.model.ModelSwapIndex(i)
-				if index > oldIndex && eOpts.modelState != ModelNew {
-					delCount = index - oldIndex
-				}
-			} else {
-				eOpts.modelState = ModelNew
-			}
+// containerModel returns the model stored at position `i` of the container `v`
+// or nil if the position holds a nil pointer.
+func (e *encodeState) containerModel(v reflect.Value, i int) ModelIface {
+	elem := v.Index(i)
+	if elem.Kind() == reflect.Ptr {
+		if elem.IsNil() {
+			return nil
+		}
+		return e.tracked(elem.Interface().(ModelIface))
+	}
+	return e.tracked(elem.Addr().Interface().(ModelIface))
+}
 
-			// Insert cumulative directives (such as skip or copy or del)
-			// if required.
-			if skipCount != 0 {
-				// Elements have been skipped
-				if eOpts.modelState != ModelSynced || index != oldIndex {
-					// Stop skipping here, because the next element cannot be skipped
-					e.WriteString(fmt.Sprintf(",%v", skipCount))
-					skipCount = 0
-				}
+// encodeModel encodes the model `m` stored in a container and marks it as synced.
+func encodeModel(e *encodeState, enc encoderFunc, elem reflect.Value, m ModelIface, state ModelState, opts encOpts) {
+	enc(e, elem, encOpts{escapeHTML: opts.escapeHTML, isModel: true, model: m, modelState: state})
+	m.ModelSynced()
+}
+
+// encodeModels encodes all models of a container which is not known by the browser.
+func encodeModels(e *encodeState, v reflect.Value, opts encOpts) {
+	enc := typeEncoder(v.Type().Elem())
+	ids := make([]int, v.Len())
+	e.WriteByte('[')
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			e.WriteByte(',')
+		}
+		m := e.containerModel(v, i)
+		if m == nil {
+			ids[i] = -1
+			e.WriteString("null")
+			continue
+		}
+		state := m.ModelTestSync(opts.model, opts.field)
+		if state != ModelNew {
+			// The model might have been synced at this path before, but
+			// the browser does not know it any more. Send it as a new model.
+			m.ModelTestSync(nil, nil)
+			state = m.ModelTestSync(opts.model, opts.field)
+		}
+		m.ModelSwapIndex(i)
+		ids[i] = m.ModelID()
+		encodeModel(e, enc, v.Index(i), m, state, opts)
+	}
+	e.WriteByte(']')
+	booksOf(opts.model).swapContainer(opts.field, containerRecord{n: len(ids), ids: ids})
+}
+
+// diffModels encodes the changes of a container of models, which is known by the browser.
+// `oldIDs` are the IDs of the models in the previous version of the container,
+// where -1 stands for nil:
+//
+//	{"_a":[0,directives...],"_l":3,"_k":[5,7]}
+//
+// The directives are applied from the end to the beginning of the previous version,
+// which is chopped to length `_l` first. The models which keep their order form the
+// longest increasing subsequence of their previous positions. All other models
+// of the previous version move. `_k` lists their IDs in the order of the new version
+// and the directive {"_k":n} inserts the next n of them. {"_k":1,"_v":diff} inserts
+// a moved model and modifies it.
+// The k-th nil pointer keeps the position of the k-th nil pointer in the previous
+// version if it is part of the subsequence. Otherwise, null is inserted.
+func diffModels(e *encodeState, v reflect.Value, opts encOpts, oldIDs []int) bool {
+	enc := typeEncoder(v.Type().Elem())
+	n := v.Len()
+	oldPos := make(map[int]int, len(oldIDs))
+	var oldNils []int
+	for i := len(oldIDs) - 1; i >= 0; i-- {
+		if oldIDs[i] >= 0 {
+			oldPos[oldIDs[i]] = i
+		}
+	}
+	for i, id := range oldIDs {
+		if id < 0 {
+			oldNils = append(oldNils, i)
+		}
+	}
+
+	// Determine the state of each model and its position in the previous version
+	// of the container, or -1 if the browser does not have the model there.
+	models := make([]ModelIface, n)
+	states := make([]ModelState, n)
+	indices := make([]int, n)
+	ids := make([]int, n)
+	first := make(map[ModelIface]int, n)
+	for i := 0; i < n; i++ {
+		m := e.containerModel(v, i)
+		models[i] = m
+		indices[i] = -1
+		if m == nil {
+			ids[i] = -1
+			states[i] = ModelNew
+			if len(oldNils) > 0 {
+				indices[i] = oldNils[0]
+				states[i] = ModelSynced
+				oldNils = oldNils[1:]
 			}
-			if delCount != 0 || insertCount != 0 {
-				diff := -delCount + insertCount
-				// Elements have been inserted or deleted
-				if diff < 0 {
-					// Some elements have been deleted
-					e.WriteString(fmt.Sprintf(",{\"_d\":%v}", -diff))
-					insertCount = 0
-				} else if diff > 0 {
-					// Some elements have been inserted
-					if eOpts.modelState != ModelNew {
-						// Complete insertion here
-						e.WriteString(fmt.Sprintf(",{\"_i\":%v}", diff))
-						insertCount = 0
-					}
-				}
-				oldIndex += delCount
-			} else if copyCount != 0 {
-				// Elements have been copied here
-				if eOpts.modelState != ModelSynced || index != copyPos+copyCount {
-					// Stop copying here
-					e.WriteString(fmt.Sprintf(",{\"_c\":%v, \"_l\":%v}", copyPos, copyCount))
-					copyCount = 0
-				}
+			continue
+		}
+		if j, ok := first[m]; ok {
+			// The model is stored several times in the container.
+			// Move it from the position it had before or send it again.
+			states[i] = ModelSynced
+			indices[i] = indices[j]
+			if indices[j] < 0 {
+				states[i] = ModelNew
 			}
-
-			if eOpts.modelState == ModelNew {
-				// Write out the new value, an insert directive will be
-				// written later.
-				insertCount++
-				e.WriteByte(',')
-				ae.elemEnc(e, elem, eOpts)
-				if eOpts.model != nil {
-					eOpts.model.ModelSynced()
-				}
-			} else if eOpts.modelState == ModelSynced {
-				if oldIndex == index {
-					// Skip one more element of the existing array
-					skipCount++
-					oldIndex++
-				} else if index < oldIndex {
-					// Copy an element from the existing array
-					if copyCount == 0 {
-						// This is the first element to copy.
-						copyPos = index
-					}
-					// The copy directive will be written out later.
-					copyCount++
-				} else {
-					panic("Ooops")
-				}
+			ids[i] = ids[j]
+			continue
+		}
+		first[m] = i
+		state := m.ModelTestSync(opts.model, opts.field)
+		if state != ModelNew {
+			if index, ok := oldPos[m.ModelID()]; ok {
+				indices[i] = index
 			} else {
-				if index < oldIndex {
-					e.WriteString(fmt.Sprintf(",{\"_t\":%v, \"_v\":", index))
-					ae.elemEnc(e, elem, eOpts)
-					e.WriteByte('}')
-				} else {
-					e.WriteByte(',')
-					ae.elemEnc(e, elem, eOpts)
-					oldIndex++
-				}
-				eOpts.model.ModelSynced()
+				// The model has been removed from the container and added again.
+				// The browser does not know it any more, hence send it as a new model.
+				m.ModelTestSync(nil, nil)
+				state = m.ModelTestSync(opts.model, opts.field)
 			}
 		}
-		if copyCount > 0 {
-			// Some elements are copied to another location
-			e.WriteString(fmt.Sprintf(",{\"_c\":%v, \"_l\":%v}", copyPos, copyCount))
+		m.ModelSwapIndex(i)
+		states[i] = state
+		ids[i] = m.ModelID()
+	}
+	stable := increasingSubsequence(indices)
+	for i, m := range models {
+		if m == nil && !stable[i] {
+			// A nil pointer cannot be moved, because it has no ID
+			indices[i] = -1
+			states[i] = ModelNew
 		}
-		if insertCount > 0 {
-			// Some elements have been inserted
-			e.WriteString(fmt.Sprintf(",{\"_i\":%v}", insertCount))
+	}
+
+	changed := false
+	e.WriteString("{\"_a\":[0")
+	oldIndex := 0
+	insertCount := 0
+	skipCount := 0
+	moveCount := 0
+	var moved []string
+	for i := 0; i < n; i++ {
+		m, state, index := models[i], states[i], indices[i]
+		isMoved := index >= 0 && !stable[i]
+
+		// Insert cumulative directives (such as skip or move or del)
+		// if required.
+		if moveCount != 0 && (!isMoved || state != ModelSynced) {
+			// Stop moving here, because the next element is not moved or it is modified
+			e.WriteString(fmt.Sprintf(",{\"_k\":%v}", moveCount))
+			moveCount = 0
 		}
-		if skipCount > 0 {
+		if skipCount != 0 && (!stable[i] || state != ModelSynced || index != oldIndex) {
+			// Stop skipping here, because the next element cannot be skipped
 			e.WriteString(fmt.Sprintf(",%v", skipCount))
 			skipCount = 0
 		}
-		e.WriteString(fmt.Sprintf("],\"_l\":%v}", oldIndex))
-		return
+		delCount := 0
+		if stable[i] {
+			delCount = index - oldIndex
+		}
+		if delCount != 0 || (insertCount != 0 && state != ModelNew) {
+			diff := -delCount + insertCount
+			// Elements have been inserted or deleted
+			if diff < 0 {
+				// Some elements have been deleted
+				e.WriteString(fmt.Sprintf(",{\"_d\":%v}", -diff))
+			} else if diff > 0 {
+				// Some elements have been inserted
+				e.WriteString(fmt.Sprintf(",{\"_i\":%v}", diff))
+			}
+			// Otherwise, the inserted elements replace the deleted ones
+			insertCount = 0
+			oldIndex += delCount
+			changed = true
+		}
+
+		if state == ModelNew {
+			// Write out the new value, an insert directive will be
+			// written later.
+			insertCount++
+			e.WriteByte(',')
+			if m == nil {
+				e.WriteString("null")
+			} else {
+				encodeModel(e, enc, v.Index(i), m, state, opts)
+			}
+			changed = true
+		} else if isMoved {
+			moved = append(moved, strconv.Itoa(ids[i]))
+			if state == ModelSynced {
+				// The move directive will be written out later
+				moveCount++
+			} else {
+				e.WriteString(",{\"_k\":1,\"_v\":")
+				encodeModel(e, enc, v.Index(i), m, state, opts)
+				e.WriteByte('}')
+			}
+			changed = true
+		} else if state == ModelSynced {
+			// Skip one more element of the existing array
+			skipCount++
+			oldIndex++
+		} else {
+			e.WriteByte(',')
+			encodeModel(e, enc, v.Index(i), m, state, opts)
+			oldIndex++
+			changed = true
+		}
+	}
+	if moveCount > 0 {
+		// Some elements have been moved here
+		e.WriteString(fmt.Sprintf(",{\"_k\":%v}", moveCount))
+	}
+	if insertCount > 0 {
+		// Some elements have been inserted
+		e.WriteString(fmt.Sprintf(",{\"_i\":%v}", insertCount))
+	}
+	if skipCount > 0 {
+		e.WriteString(fmt.Sprintf(",%v", skipCount))
 	}
+	e.WriteString(fmt.Sprintf("],\"_l\":%v", oldIndex))
+	if len(moved) > 0 {
+		e.WriteString(",\"_k\":[" + strings.Join(moved, ",") + "]")
+	}
+	e.WriteByte('}')
+	booksOf(opts.model).swapContainer(opts.field, containerRecord{n: n, ids: ids})
+	return changed || oldIndex != len(oldIDs)
+}
+
+// increasingSubsequence returns which elements of `indices` belong to a longest
+// strictly increasing subsequence of the non-negative elements.
+func increasingSubsequence(indices []int) []bool {
+	// tails[k] is the position of the smallest last element of an increasing
+	// subsequence of length k+1 found so far
+	var tails []int
+	prev := make([]int, len(indices))
+	for i, index := range indices {
+		if index < 0 {
+			continue
+		}
+		k := sort.Search(len(tails), func(k int) bool { return indices[tails[k]] >= index })
+		prev[i] = -1
+		if k > 0 {
+			prev[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	result := make([]bool, len(indices))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			result[i] = true
+		}
+	}
+	return result
+}
 
+// encodeContainers encodes all nested containers of a container which is not known by the browser.
+func encodeContainers(e *encodeState, v reflect.Value, opts encOpts) {
+	books := booksOf(opts.model)
 	e.WriteByte('[')
-	for i := 0; i < n; i++ {
+	for i := 0; i < v.Len(); i++ {
 		if i > 0 {
 			e.WriteByte(',')
 		}
-		elem := v.Index(i)
-		if opts.isModel && opts.fieldIsSliceOfModelPtrs {
-			var eOpts = opts
-			eOpts.fieldIsSliceOfModelPtrs = false
-			eOpts.isModel = true
-			eOpts.model = elem.Interface().(ModelIface)
-			eOpts.modelState = eOpts.model.ModelTestSync(opts.model, opts.field)
-			eOpts.model.ModelSwapIndex(i)
-			ae.elemEnc(e, elem, eOpts)
-			// println("Serialized slice element", i, eOpts.modelState)
-			eOpts.model.ModelSynced()
-		} else if opts.isModel && opts.fieldIsSliceOfModels {
-			var eOpts = opts
-			eOpts.fieldIsSliceOfModelPtrs = false
-			eOpts.isModel = true
-			addr := elem.Addr()
-			eOpts.model = addr.Interface().(ModelIface)
-			eOpts.modelState = eOpts.model.ModelTestSync(opts.model, opts.field)
-			eOpts.model.ModelSwapIndex(i)
-			ae.elemEnc(e, elem, eOpts)
-			// println("Serialized slice element", i, eOpts.modelState)
-			eOpts.model.ModelSynced()
-		} else {
-			ae.elemEnc(e, elem, opts)
-		}
+		eOpts := opts
+		eOpts.field = books.elemPath(opts.field, i)
+		eOpts.modelState = ModelNew
+		encodeContainer(e, v.Index(i), eOpts)
 	}
 	e.WriteByte(']')
 }
 
+// diffContainers encodes the changes of a container of nested containers.
+// The browser knows the first `oldLen` nested containers.
+// Nested containers at the same position are diffed with each other.
+// Appended containers are inserted and containers beyond the end are removed.
+func diffContainers(e *encodeState, v reflect.Value, opts encOpts, oldLen int) bool {
+	n := v.Len()
+	common := n
+	if oldLen < common {
+		common = oldLen
+	}
+	changed := n != oldLen
+	books := booksOf(opts.model)
+	e.WriteString("{\"_a\":[0")
+	scratch := e.newScratch()
+	defer encodeStatePool.Put(scratch)
+	skipCount := 0
+	for i := 0; i < common; i++ {
+		eOpts := opts
+		eOpts.field = books.elemPath(opts.field, i)
+		scratch.Reset()
+		if !encodeContainer(scratch, v.Index(i), eOpts) {
+			skipCount++
+			continue
+		}
+		if skipCount != 0 {
+			e.WriteString(fmt.Sprintf(",%v", skipCount))
+			skipCount = 0
+		}
+		e.WriteByte(',')
+		e.Write(scratch.Bytes())
+		changed = true
+	}
+	if skipCount != 0 {
+		e.WriteString(fmt.Sprintf(",%v", skipCount))
+	}
+	for i := common; i < n; i++ {
+		eOpts := opts
+		eOpts.field = books.elemPath(opts.field, i)
+		eOpts.modelState = ModelNew
+		e.WriteByte(',')
+		encodeContainer(e, v.Index(i), eOpts)
+	}
+	if n > common {
+		e.WriteString(fmt.Sprintf(",{\"_i\":%v}", n-common))
+	}
+	// Forget the removed containers
+	for i := n; i < oldLen; i++ {
+		books.forgetContainer(books.elemPath(opts.field, i))
+	}
+	e.WriteString(fmt.Sprintf("],\"_l\":%v}", common))
+	return changed
+}
+
 func newArrayEncoder(t reflect.Type) encoderFunc {
 	enc := arrayEncoder{typeEncoder(t.Elem())}
 	return enc.encode
@@ -1140,16 +1624,19 @@ type Field struct {
 	nameNonEsc  string // `"` + name + `":`
 	nameEscHTML string // `"` + HTMLEscape(name) + `":`
 
-	tag             bool
-	index           []int
-	typ             reflect.Type
-	omitEmpty       bool
-	quoted          bool
-	isModel         bool
-	isModelPtr      bool
-	isModelSlice    bool
-	isModelSlicePtr bool
-	encoder         encoderFunc
+	tag              bool
+	index            []int
+	typ              reflect.Type
+	omitEmpty        bool
+	quoted           bool
+	isModel          bool
+	isModelPtr       bool
+	isModelContainer bool
+	isModelMapPtr    bool
+	isMap            bool
+	readonly         bool
+	lazy             bool
+	encoder          encoderFunc
 }
 
 // byIndex sorts field by index sequence.
@@ -1228,14 +1715,23 @@ func typeFields(t reflect.Type) structFields {
 				if !isValidTag(name) {
 					name = ""
 				}
+				// The goui tag overrides the name of the json tag
+				gname, gopts := parseGouiTag(sf.Tag.Get("goui"))
+				if gname == "-" {
+					continue
+				}
+				if gname != "" && isValidTag(gname) {
+					name = gname
+				}
 				index := make([]int, len(f.index)+1)
 				copy(index, f.index)
 				index[len(f.index)] = i
 
 				isModel := false
 				isModelPtr := false
-				isModelSlice := false
-				isModelSlicePtr := false
+				isModelContainer := false
+				isModelMapPtr := false
+				isMap := false
 
 				ft := sf.Type
 				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
@@ -1245,15 +1741,12 @@ func typeFields(t reflect.Type) structFields {
 				} else if ft.Kind() == reflect.Struct {
 					isModel = reflect.PtrTo(ft).Implements(modelIfaceType)
 					// println("Struct found", ft.Name(), isModel)
-				} else if ft.Kind() == reflect.Slice {
+				} else if ft.Kind() == reflect.Slice || ft.Kind() == reflect.Array {
+					isModelContainer = containsModels(ft)
+				} else if ft.Kind() == reflect.Map {
 					elem := ft.Elem()
-					if elem.Name() == "" && elem.Kind() == reflect.Ptr {
-						isModelSlicePtr = elem.Implements(modelIfaceType)
-						// println("Slice ptr found", sf.Name, isModel)
-					} else if elem.Kind() == reflect.Struct {
-						isModelSlice = reflect.PtrTo(elem).Implements(modelIfaceType)
-						// println("Slice found", sf.Name, isModel)
-					}
+					isModelMapPtr = elem.Name() == "" && elem.Kind() == reflect.Ptr && elem.Implements(modelIfaceType)
+					isMap = !isModelMapPtr
 				}
 
 				// Only strings, floats, integers, and booleans can be quoted.
@@ -1276,16 +1769,19 @@ func typeFields(t reflect.Type) structFields {
 						name = sf.Name
 					}
 					field := Field{
-						name:            name,
-						tag:             tagged,
-						index:           index,
-						typ:             ft,
-						omitEmpty:       opts.Contains("omitempty"),
-						quoted:          quoted,
-						isModel:         isModel,
-						isModelPtr:      isModelPtr,
-						isModelSlice:    isModelSlice,
-						isModelSlicePtr: isModelSlicePtr,
+						name:             name,
+						tag:              tagged,
+						index:            index,
+						typ:              ft,
+						omitEmpty:        opts.Contains("omitempty"),
+						quoted:           quoted,
+						isModel:          isModel,
+						isModelPtr:       isModelPtr,
+						isModelContainer: isModelContainer,
+						isModelMapPtr:    isModelMapPtr,
+						isMap:            isMap,
+						readonly:         gopts.Contains("readonly"),
+						lazy:             gopts.Contains("lazy"),
 					}
 					field.nameBytes = []byte(field.name)
 					field.equalFold = foldFunc(field.nameBytes)
@@ -1547,6 +2043,17 @@ func parseTag(tag string) (string, tagOptions) {
 	return tag, tagOptions("")
 }
 
+// parseGouiTag splits a struct field's goui tag into its name and
+// comma-separated options. The name can be omitted if the first option
+// is a keyword, e.g. `goui:"readonly"`.
+func parseGouiTag(tag string) (string, tagOptions) {
+	name, opts := parseTag(tag)
+	if name == "readonly" || name == "lazy" {
+		return "", tagOptions(tag)
+	}
+	return name, opts
+}
+
 // Contains reports whether a comma-separated list of options
 // contains a particular substr flag. substr must be surrounded by a
 // string boundary or commas.
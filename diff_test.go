@@ -1,6 +1,7 @@
 package goui
 
 import (
+	"fmt"
 	"testing"
 )
 
@@ -34,6 +35,24 @@ type List2Model struct {
 	List []DetailsModel
 }
 
+// checkDiff compares the diff of the model `m` with `expected`.
+// The IDs are assigned by MarshalDiff, thus expected is a format string
+// for the IDs of `models`.
+func checkDiff(t *testing.T, m ModelIface, expected string, models ...ModelIface) {
+	t.Helper()
+	data, err := MarshalDiff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []interface{}
+	for _, m := range models {
+		ids = append(ids, m.ModelID())
+	}
+	if expected = fmt.Sprintf(expected, ids...); string(data) != expected {
+		t.Fatalf("Expected %v, got %v", expected, string(data))
+	}
+}
+
 func TestDiff(t *testing.T) {
 	m := &MyModel{}
 	m.Age = 42
@@ -199,3 +218,225 @@ func TestList2Diff(t *testing.T) {
 	}
 	println(string(data))
 }
+
+func TestKeyedMoveDiff(t *testing.T) {
+	m := &ListModel{}
+	for i := 0; i < 10000; i++ {
+		m.List = append(m.List, &DetailsModel{Name: fmt.Sprintf("Row %v", i)})
+	}
+	if _, err := MarshalDiff(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dragging a row transmits its ID only
+	row := m.List[9000]
+	copy(m.List[11:9001], m.List[10:9000])
+	m.List[10] = row
+	m.ModelDirty()
+	data, err := MarshalDiff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`{"m":{"List":{"_a":[0,10,{"_k":1},8990,{"_d":1},999],"_l":10000,"_k":[%v]}}}`, row.ModelID())
+	if string(data) != expected {
+		t.Fatalf("Expected %v, got %v", expected, string(data))
+	}
+
+	// Reversing the rows keeps one row in place and moves all others
+	for i, j := 0, len(m.List)-1; i < j; i, j = i+1, j-1 {
+		m.List[i], m.List[j] = m.List[j], m.List[i]
+	}
+	m.ModelDirty()
+	data, err = MarshalDiff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := ""
+	for _, row := range m.List[:9999] {
+		ids += fmt.Sprintf(",%v", row.ModelID())
+	}
+	expected = fmt.Sprintf(`{"m":{"List":{"_a":[0,{"_k":9999},1],"_l":1,"_k":[%v]}}}`, ids[1:])
+	if string(data) != expected {
+		t.Fatalf("Expected %v, got %v", expected[:100], string(data)[:100])
+	}
+}
+
+type MapModel struct {
+	Model
+	Index  map[string]*DetailsModel
+	Counts map[string]int
+}
+
+func TestMapDiff(t *testing.T) {
+	m := &MapModel{}
+	m.Index = map[string]*DetailsModel{"a": {Name: "A"}, "b": {Name: "B"}}
+	m.Counts = map[string]int{"x": 1, "y": 2}
+	a, b := m.Index["a"], m.Index["b"]
+
+	checkDiff(t, m, `{"m":{"_id":%v,"Index":{"a":{"_id":%v,"Name":"A"},"b":{"_id":%v,"Name":"B"}},"Counts":{"x":1,"y":2}}}`, m, a, b)
+
+	// Modify one entry
+	b.Name = "BB"
+	b.ModelDirty()
+	checkDiff(t, m, `{"m":{"Index":{"_m":{"b":{"Name":"BB"}}}}}`)
+
+	// Add, remove and move entries
+	c := &DetailsModel{Name: "C"}
+	m.Index["c"] = c
+	delete(m.Index, "a")
+	m.Index["d"] = b
+	delete(m.Index, "b")
+	m.Counts["x"] = 3
+	delete(m.Counts, "y")
+	m.Counts["z"] = 4
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Index":{"_m":{},"_s":{"c":{"_id":%v,"Name":"C"}},"_c":{"d":"b"},"_r":["a","b"]},"Counts":{"_m":{},"_s":{"x":3,"z":4},"_r":["y"]}}}`, c)
+
+	// Nothing changed
+	checkDiff(t, m, `{"m":null}`)
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{}}`)
+
+	// Replace the map
+	m.Counts = nil
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Counts":null}}`)
+	m.Counts = map[string]int{"x": 1}
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Counts":{"_m":{},"_n":1,"_s":{"x":1}}}}`)
+}
+
+// ForeignModel implements ModelIface without embedding Model
+type ForeignModel struct {
+	model  Model
+	Counts map[string]int
+}
+
+func (f *ForeignModel) ModelDirty()            { f.model.ModelDirty() }
+func (f *ForeignModel) ModelChildDirty()       { f.model.ModelChildDirty() }
+func (f *ForeignModel) ModelSynced()           { f.model.ModelSynced() }
+func (f *ForeignModel) ModelState() ModelState { return f.model.ModelState() }
+func (f *ForeignModel) ModelSwapIndex(i int) int {
+	return f.model.ModelSwapIndex(i)
+}
+func (f *ForeignModel) ModelTestSync(parent ModelIface, field *Field) ModelState {
+	return f.model.ModelTestSync(parent, field)
+}
+func (f *ForeignModel) ModelID() int { return f.model.ModelID() }
+
+func TestForeignModelDiff(t *testing.T) {
+	m := &ForeignModel{Counts: map[string]int{"x": 1, "y": 2}}
+	checkDiff(t, m, `{"m":{"_id":%v,"Counts":{"x":1,"y":2}}}`, m)
+
+	// Without bookkeeping, the map is sent entirely and replaces the old one
+	delete(m.Counts, "y")
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Counts":{"_m":{},"_n":1,"_s":{"x":1}}}}`)
+}
+
+type TagModel struct {
+	Model
+	Title    string   `json:"title" goui:"caption"`
+	Password string   `goui:"-"`
+	History  []string `goui:"lazy"`
+	Notes    string   `goui:",readonly"`
+}
+
+func TestTagDiff(t *testing.T) {
+	m := &TagModel{Title: "Doc", Password: "secret", History: []string{"v1"}, Notes: "n"}
+	data, err := MarshalDiff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`{"m":{"_id":%v,"caption":"Doc","Notes":"n"}}`, m.ModelID())
+	if string(data) != expected {
+		t.Fatalf("Expected %v, got %v", expected, string(data))
+	}
+}
+
+type TreeModel struct {
+	Model
+	Name  string
+	Left  *TreeModel
+	Right *TreeModel
+}
+
+func TestMoveDiff(t *testing.T) {
+	b := &TreeModel{Name: "B"}
+	a := &TreeModel{Name: "A", Left: b}
+	m := &TreeModel{Name: "Root", Left: a}
+	if _, err := MarshalDiff(m); err != nil {
+		t.Fatal(err)
+	}
+
+	// The moved model is sent with its children
+	m.Left, m.Right = nil, a
+	m.ModelDirty()
+	data, err := MarshalDiff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf(`{"m":{"Name":"Root","Left":null,"Right":{"_id":%v,"Name":"A","Left":{"_id":%v,"Name":"B","Left":null,"Right":null},"Right":null}}}`, a.ModelID(), b.ModelID())
+	if string(data) != expected {
+		t.Fatalf("Expected %v, got %v", expected, string(data))
+	}
+}
+
+type GridModel struct {
+	Model
+	Rows  [][]*DetailsModel
+	Slots [3]*DetailsModel
+	Cells [2]DetailsModel
+	Any   interface{}
+}
+
+func TestContainerDiff(t *testing.T) {
+	a, b, c := &DetailsModel{Name: "A"}, &DetailsModel{Name: "B"}, &DetailsModel{Name: "C"}
+	s := &DetailsModel{Name: "S"}
+	x := &DetailsModel{Name: "X"}
+	m := &GridModel{Rows: [][]*DetailsModel{{a, b}, {c}}, Any: []*DetailsModel{x}}
+	m.Slots[0] = s
+
+	checkDiff(t, m, `{"m":{"_id":%v,"Rows":[[{"_id":%v,"Name":"A"},{"_id":%v,"Name":"B"}],[{"_id":%v,"Name":"C"}]],"Slots":[{"_id":%v,"Name":"S"},null,null],"Cells":[{"_id":%v,"Name":""},{"_id":%v,"Name":""}],"Any":[{"_id":%v,"Name":"X"}]}}`, m, a, b, c, s, &m.Cells[0], &m.Cells[1], x)
+
+	// Nothing changed, although the array holds nil pointers
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{}}`)
+
+	// Modify models in a nested slice, an array and a slice held by an interface
+	b.Name = "BB"
+	b.ModelDirty()
+	m.Cells[1].Name = "Cell"
+	m.Cells[1].ModelDirty()
+	x.Name = "XX"
+	x.ModelDirty()
+	checkDiff(t, m, `{"m":{"Rows":{"_a":[0,{"_a":[0,1,{"Name":"BB"}],"_l":2},1],"_l":2},"Cells":{"_a":[0,1,{"Name":"Cell"}],"_l":2},"Any":{"_a":[0,{"Name":"XX"}],"_l":1}}}`)
+
+	// Append a row and fill a slot
+	d := &DetailsModel{Name: "D"}
+	m.Rows = append(m.Rows, []*DetailsModel{d})
+	m.Slots[2] = s
+	m.Slots[0] = nil
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Rows":{"_a":[0,2,[{"_id":%v,"Name":"D"}],{"_i":1}],"_l":2},"Slots":{"_a":[0,{"_d":1},2,{"_k":1}],"_l":3,"_k":[%v]}}}`, d, s)
+
+	// A model moved to another row is sent as a new model
+	m.Rows[0], m.Rows[1] = m.Rows[1], m.Rows[0]
+	m.Rows = m.Rows[:2]
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Rows":{"_a":[0,{"_a":[0,{"_id":%v,"Name":"C"},{"_i":1}],"_l":0},{"_a":[0,{"_id":%v,"Name":"A"},{"_id":%v,"Name":"BB"},{"_i":2}],"_l":0}],"_l":2}}}`, c, a, b)
+
+	// The interface holds a model, a plain value and a slice again
+	m.Any = x
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Any":{"_id":%v,"Name":"XX"}}}`, x)
+	m.Any = "plain"
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Any":"plain"}}`)
+	m.Any = x
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Any":{"_id":%v,"Name":"XX"}}}`, x)
+	m.Any = []*DetailsModel{x}
+	m.ModelDirty()
+	checkDiff(t, m, `{"m":{"Any":[{"_id":%v,"Name":"XX"}]}}`, x)
+}
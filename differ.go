@@ -0,0 +1,91 @@
+package goui
+
+import (
+	"reflect"
+)
+
+// Differ computes the diffs of a tree of models for one consumer, e.g. a log or
+// a replica of the model in another process.
+// In contrast to MarshalDiff and Window, a Differ does not store the sync state in
+// the models. It keeps its own sync state and assigns its own model IDs.
+// Hence, the same model tree can be synced to several consumers independently,
+// each with its own Differ, while it is synced to the browser by a Window.
+//
+// Models must call ModelDirty when their fields change, as for a Window.
+// Model structs stored by value, e.g. in a []M, are identified by their address.
+// If the address changes, e.g. because an append reallocates the slice, they are sent again.
+//
+// A Differ must not be used concurrently and the model must not be modified while Diff is running.
+type Differ struct {
+	ids     int64
+	shadows map[ModelIface]*shadowModel
+}
+
+// shadowModel keeps the sync state of a model on behalf of a Differ.
+type shadowModel struct {
+	Model
+	// revision of the model when it has been diffed the last time
+	seen uint64
+}
+
+// NewDiffer returns a Differ which has not synced any model yet.
+// The first diff contains the entire model.
+func NewDiffer() *Differ {
+	return &Differ{shadows: make(map[ModelIface]*shadowModel)}
+}
+
+// Diff returns the changes of the tree of models rooted at `model` since the last call to Diff.
+// The format is the same as the format of MarshalDiff.
+func (d *Differ) Diff(model ModelIface) ([]byte, error) {
+	v := reflect.ValueOf(model)
+	if model == nil || v.Kind() != reflect.Ptr || v.IsNil() {
+		return []byte("{\"m\":null}"), nil
+	}
+	// Mark the models which have been modified since the last diff.
+	// Models which are no longer part of the tree are forgotten.
+	shadows := make(map[ModelIface]*shadowModel, len(d.shadows))
+	d.refresh(model, v.Elem(), shadows)
+	d.shadows = shadows
+	return marshalDiff(model, nil, false, d)
+}
+
+// Reset forgets the sync state. The next diff contains the entire model with new model IDs.
+func (d *Differ) Reset() {
+	d.shadows = make(map[ModelIface]*shadowModel)
+}
+
+// ModelID returns the ID which the Differ has assigned to `m`.
+// The result is false if `m` has not been diffed yet.
+func (d *Differ) ModelID(m ModelIface) (int, bool) {
+	s, ok := d.shadows[m]
+	if !ok || s.ModelState() == ModelNew {
+		return 0, false
+	}
+	return s.ModelID(), true
+}
+
+// refresh copies the shadows of the model `m`, whose struct is `v`, and its children
+// to `shadows` and marks the shadows of modified models as dirty.
+func (d *Differ) refresh(m ModelIface, v reflect.Value, shadows map[ModelIface]*shadowModel) {
+	if s, ok := d.shadows[m]; ok {
+		shadows[m] = s
+		if revision := booksOf(m).currentRevision(); revision != s.seen {
+			s.seen = revision
+			s.ModelDirty()
+		}
+	}
+	for _, c := range childModels(v) {
+		d.refresh(c.Interface().(ModelIface), c.Elem(), shadows)
+	}
+}
+
+// shadow returns the shadow which keeps the sync state of `m`.
+func (d *Differ) shadow(m ModelIface) ModelIface {
+	s, ok := d.shadows[m]
+	if !ok {
+		s = &shadowModel{seen: booksOf(m).currentRevision()}
+		s.ids = &d.ids
+		d.shadows[m] = s
+	}
+	return s
+}
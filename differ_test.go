@@ -0,0 +1,55 @@
+package goui
+
+import "testing"
+
+func TestDiffer(t *testing.T) {
+	a, b := &DetailsModel{Name: "A"}, &DetailsModel{Name: "B"}
+	m := &ListModel{List: []*DetailsModel{a, b}}
+	d1 := NewDiffer()
+	d2 := NewDiffer()
+
+	check := func(d *Differ, expected string) {
+		t.Helper()
+		data, err := d.Diff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Expected %v, got %v", expected, string(data))
+		}
+	}
+
+	// Each differ assigns its own IDs
+	full := `{"m":{"_id":0,"List":[{"_id":1,"Name":"A"},{"_id":2,"Name":"B"}]}}`
+	check(d1, full)
+	check(d1, `{"m":null}`)
+
+	// Modifications are seen by all differs and MarshalDiff
+	b.Name = "BB"
+	b.ModelDirty()
+	check(d1, `{"m":{"List":{"_a":[0,1,{"Name":"BB"}],"_l":2}}}`)
+	check(d1, `{"m":null}`)
+	check(d2, `{"m":{"_id":0,"List":[{"_id":1,"Name":"A"},{"_id":2,"Name":"BB"}]}}`)
+	if _, err := MarshalDiff(m); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := d1.ModelID(b); !ok || id != 2 {
+		t.Fatalf("Unexpected ID %v", id)
+	}
+
+	// The differs track the structure of the model separately
+	c := &DetailsModel{Name: "C"}
+	m.List = []*DetailsModel{b, c}
+	m.ModelDirty()
+	check(d1, `{"m":{"List":{"_a":[0,{"_d":1},1,{"_id":3,"Name":"C"},{"_i":1}],"_l":2}}}`)
+	a.Name = "AA"
+	a.ModelDirty()
+	check(d1, `{"m":null}`)
+	if _, ok := d1.ModelID(a); ok {
+		t.Fatal("Removed models are forgotten")
+	}
+	check(d2, `{"m":{"List":{"_a":[0,{"_d":1},1,{"_id":3,"Name":"C"},{"_i":1}],"_l":2}}}`)
+
+	d1.Reset()
+	check(d1, `{"m":{"_id":4,"List":[{"_id":5,"Name":"BB"},{"_id":6,"Name":"C"}]}}`)
+}
@@ -0,0 +1,56 @@
+package goui
+
+import (
+	"golang.org/x/net/websocket"
+)
+
+// Encoding is the format of the messages exchanged by a window and the browser.
+type Encoding int
+
+const (
+	// EncodingJSON sends JSON text messages. This is the default.
+	EncodingJSON Encoding = iota
+	// EncodingMsgPack sends MessagePack binary messages. Numbers are encoded more compactly
+	// and byte slices in the model are sent as binary data instead of base64 strings.
+	// In JavaScript, these byte slices are Uint8Arrays.
+	EncodingMsgPack
+)
+
+// The websocket subprotocols offered by the browser
+const (
+	protocolJSON    = "goui.json"
+	protocolMsgPack = "goui.msgpack"
+)
+
+// SetEncoding selects the encoding of model diffs, remote method invocations, results,
+// events and calls. The browser offers all encodings when it opens the websocket and
+// the window picks `enc` if the browser supports it, or JSON otherwise.
+// The encoding takes effect when the browser connects the next time.
+// Byte slices returned by remote methods are base64 strings in all encodings.
+func (s *Window) SetEncoding(enc Encoding) {
+	s.lock.Lock()
+	s.encoding = enc
+	s.lock.Unlock()
+}
+
+// negotiate picks the websocket subprotocol of a new connection.
+// The caller must hold the lock of the window.
+func (s *Window) negotiate(config *websocket.Config) {
+	offered := config.Protocol
+	config.Protocol = nil
+	for _, p := range offered {
+		if p == protocolMsgPack && s.encoding == EncodingMsgPack {
+			config.Protocol = []string{p}
+			return
+		}
+		if p == protocolJSON {
+			config.Protocol = []string{p}
+		}
+	}
+}
+
+// isBinary returns true if the connection uses EncodingMsgPack.
+func isBinary(conn *websocket.Conn) bool {
+	p := conn.Config().Protocol
+	return len(p) == 1 && p[0] == protocolMsgPack
+}
@@ -0,0 +1,76 @@
+package goui
+
+import "errors"
+
+// GouiError is an error that carries a code and optional data to JavaScript.
+// If a remote method returns a GouiError, the JavaScript promise is rejected
+// with an Error object whose `code` and `data` properties are set accordingly.
+type GouiError interface {
+	error
+	// ErrorCode returns a code that JavaScript code can switch on.
+	ErrorCode() string
+	// ErrorData returns additional data which is marshalled as JSON, or nil.
+	ErrorData() interface{}
+}
+
+// Codes of the errors reported by goui itself.
+const (
+	// ErrUnknownMethod is reported if the remote object has no such method.
+	ErrUnknownMethod = "goui:unknown_method"
+	// ErrParameterCount is reported if the number of parameters does not match.
+	ErrParameterCount = "goui:parameter_count"
+	// ErrBadParameter is reported if a parameter cannot be decoded.
+	ErrBadParameter = "goui:bad_parameter"
+	// ErrInternal is reported if the result of a call cannot be encoded.
+	ErrInternal = "goui:internal"
+	// ErrBadPatch is reported if the browser sends a patch for an unknown model or field
+	// or if the value does not match the type of the field.
+	ErrBadPatch = "goui:bad_patch"
+	// ErrReadOnly is reported if the browser sends a patch for a field tagged `goui:"readonly"`.
+	ErrReadOnly = "goui:readonly"
+	// ErrNoHistory is reported if there is nothing to undo or redo.
+	ErrNoHistory = "goui:no_history"
+)
+
+// Error implements GouiError.
+type Error struct {
+	Code    string
+	Message string
+	Data    interface{}
+}
+
+// NewError returns an error with a code, a message and optional data.
+func NewError(code string, message string, data interface{}) *Error {
+	return &Error{Code: code, Message: message, Data: data}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorCode returns e.Code.
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+// ErrorData returns e.Data.
+func (e *Error) ErrorData() interface{} {
+	return e.Data
+}
+
+// errorMessage is the representation of an error sent to the client.
+type errorMessage struct {
+	Code    string      `json:"code,omitempty"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func newErrorMessage(err error) *errorMessage {
+	e := &errorMessage{Message: err.Error()}
+	var gerr GouiError
+	if errors.As(err, &gerr) {
+		e.Code = gerr.ErrorCode()
+		e.Data = gerr.ErrorData()
+	}
+	return e
+}
@@ -1,5 +1,5 @@
 module github.com/weistn/goui
 
-go 1.17
+go 1.18
 
 require golang.org/x/net v0.0.0-20220225172249-27dd8689420f
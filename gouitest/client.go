@@ -0,0 +1,633 @@
+// Package gouitest connects to a goui Window without launching a browser.
+//
+// The Client performs the same steps as the browser does: It exchanges the
+// secret token for the auth cookie, opens the websocket and keeps a copy of
+// the synced model. This allows to test remote objects and models end-to-end
+// with `go test`:
+//
+//	w := goui.NewWindow("/", remote, model)
+//	c, err := gouitest.Start(w)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer c.Close()
+//	var result int
+//	err = c.Invoke(&result, "Double", 21)
+package gouitest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/weistn/goui"
+	"github.com/weistn/goui/replica"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultTimeout is the time a Client waits for answers from the Window.
+var DefaultTimeout = 10 * time.Second
+
+// Client is a headless replacement for the browser side of a goui Window.
+type Client struct {
+	// Timeout limits the time Invoke waits for a result.
+	Timeout time.Duration
+
+	window  *goui.Window
+	origin  string
+	conn    *websocket.Conn
+	lock    sync.Mutex
+	counter int
+	pending map[int]chan *resultMessage
+	model   interface{}
+	ready   chan bool
+	events  *queue
+	calls   *queue
+	closed  bool
+	// version is the latest model version applied
+	version int
+	// config opens the websocket
+	config *websocket.Config
+	// done is closed when reading from the websocket ends
+	done     chan bool
+	gotModel bool
+	// drop counts the model messages to be dropped
+	drop     int
+	resyncs  int
+	resyncOn bool
+}
+
+// Event is an event sent by Window.SendEvent.
+type Event struct {
+	Name string
+	Data json.RawMessage
+}
+
+// Call is a JavaScript function call requested by Window.Call or Window.Invoke.
+type Call struct {
+	Name string
+	Args []json.RawMessage
+	// ID is non-zero if the window waits for the result, i.e. the call has been
+	// started by Window.Invoke. Use Return or Throw to answer the call.
+	ID int
+}
+
+// message is the union of all messages sent from the Window to the browser.
+type message struct {
+	Model     *json.RawMessage  `json:"m"`
+	Event     json.RawMessage   `json:"ev"`
+	EventName *string           `json:"n"`
+	Func      *string           `json:"f"`
+	Arguments []json.RawMessage `json:"a"`
+	Value     json.RawMessage   `json:"v"`
+	Error     json.RawMessage   `json:"e"`
+	ID        int               `json:"id"`
+	Version   int               `json:"ver"`
+	Full      bool              `json:"full"`
+}
+
+// resultMessage is the answer to an Invoke
+type resultMessage struct {
+	value json.RawMessage
+	err   error
+}
+
+// invocation is sent to the Window to call a remote method.
+type invocation struct {
+	Name    string        `json:"n"`
+	Message []interface{} `json:"v"`
+	ID      int           `json:"id,omitempty"`
+}
+
+var refreshRegexp = regexp.MustCompile(`url=([^"]*)"`)
+
+func newClient() *Client {
+	return &Client{
+		Timeout: DefaultTimeout,
+		pending: make(map[int]chan *resultMessage),
+		ready:   make(chan bool),
+		events:  newQueue(),
+		calls:   newQueue(),
+	}
+}
+
+// Start starts a window created by goui.NewWindow and connects a Client instead of a browser.
+// Start returns after the initial model has been received.
+func Start(w *goui.Window) (*Client, error) {
+	c := newClient()
+	c.window = w
+	w.SetLauncher(goui.LauncherFunc(c.connect))
+	if err := w.Start(); err != nil {
+		return nil, err
+	}
+	return c, c.waitReady()
+}
+
+// Open opens a new window of the app and connects a Client instead of a browser.
+// Open returns after the initial model has been received.
+// Open changes the launcher of the app.
+func Open(app *goui.App, initialPath string, model goui.ModelIface) (*goui.Window, *Client, error) {
+	c := newClient()
+	app.SetLauncher(goui.LauncherFunc(c.connect))
+	w, err := app.OpenWindow(initialPath, model)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.window = w
+	return w, c, c.waitReady()
+}
+
+func (c *Client) waitReady() error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-time.After(c.Timeout):
+		return errors.New("gouitest: no initial model")
+	}
+}
+
+// connect performs the token-to-cookie handshake and opens the websocket.
+func (c *Client) connect(u string) error {
+	loc, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+	c.origin = loc.Scheme + "://" + loc.Host
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return err
+	}
+	hc := &http.Client{Jar: jar}
+	// Exchange the token for a cookie. The window answers with a page
+	// that redirects to the initial page.
+	body, err := get(hc, u)
+	if err != nil {
+		return err
+	}
+	match := refreshRegexp.FindStringSubmatch(body)
+	if match == nil {
+		return errors.New("gouitest: token has not been accepted")
+	}
+	initial, err := url.Parse(html.UnescapeString(match[1]))
+	if err != nil {
+		return err
+	}
+	// Visit the initial page, just like the browser does
+	if _, err := get(hc, initial.String()); err != nil {
+		return err
+	}
+
+	// Like the browser, the Client can decompress messages
+	socket := "ws://" + loc.Host + "/_socket?window=" + url.QueryEscape(initial.Query().Get("goui_window")) + "&compress=1"
+	config, err := websocket.NewConfig(socket, c.origin)
+	if err != nil {
+		return err
+	}
+	var cookies []string
+	for _, cookie := range jar.Cookies(loc) {
+		cookies = append(cookies, cookie.String())
+	}
+	config.Header.Set("Cookie", strings.Join(cookies, "; "))
+	c.config = config
+	conn, err := c.dial(0)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.done = make(chan bool)
+	go c.read()
+	return nil
+}
+
+// dial opens a websocket. If `version` is not zero, it tells the window
+// which model version the Client has applied.
+func (c *Client) dial(version int) (*websocket.Conn, error) {
+	config := *c.config
+	if version != 0 {
+		loc := *config.Location
+		q := loc.Query()
+		q.Set("ver", strconv.Itoa(version))
+		loc.RawQuery = q.Encode()
+		config.Location = &loc
+	}
+	// Offer all encodings, like the browser does
+	config.Protocol = []string{"goui.msgpack", "goui.json"}
+	return websocket.DialConfig(&config)
+}
+
+// Reconnect closes the websocket and opens a new one, like the browser does when
+// the connection is lost. The Client tells the window which model version it has applied,
+// such that the window can send the model messages the Client has missed.
+// Reconnect returns after the new websocket has been opened.
+func (c *Client) Reconnect() error {
+	c.conn.Close()
+	<-c.done
+	c.lock.Lock()
+	version := c.version
+	c.lock.Unlock()
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		conn, err := c.dial(version)
+		if err == nil {
+			c.lock.Lock()
+			c.conn = conn
+			c.closed = false
+			c.done = make(chan bool)
+			c.lock.Unlock()
+			go c.read()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		// The window has not yet noticed that the old websocket is closed
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func get(hc *http.Client, u string) (string, error) {
+	resp, err := hc.Get(u)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("gouitest: %v is unauthorized", u)
+	}
+	return string(data), nil
+}
+
+// read processes all messages sent by the window.
+func (c *Client) read() {
+	defer close(c.done)
+	for {
+		var data []byte
+		err := websocket.Message.Receive(c.conn, &data)
+		if err == nil && len(data) > 0 && data[0] == 0 {
+			// The message is compressed
+			data, err = decompress(data[1:])
+		}
+		if err == nil && c.Encoding() == goui.EncodingMsgPack {
+			data, err = goui.MsgPackToJSON(data)
+		}
+		if err != nil {
+			c.lock.Lock()
+			for id, ch := range c.pending {
+				ch <- &resultMessage{err: err}
+				delete(c.pending, id)
+			}
+			c.closed = true
+			c.lock.Unlock()
+			return
+		}
+		var msg message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		if msg.Model != nil {
+			var diff interface{}
+			if err := json.Unmarshal(*msg.Model, &diff); err != nil {
+				continue
+			}
+			c.lock.Lock()
+			if c.drop > 0 {
+				// Simulate a lost message
+				c.drop--
+				c.lock.Unlock()
+				continue
+			}
+			if !msg.Full && msg.Version != c.version+1 {
+				// A model message is missing. Ask for the entire model, like the browser does.
+				resync := !c.resyncOn
+				if resync {
+					c.resyncOn = true
+					c.resyncs++
+				}
+				c.lock.Unlock()
+				if resync {
+					c.send(&invocation{Name: "goui:resync"})
+				}
+				continue
+			}
+			model, err := replica.Apply(c.model, diff)
+			if err != nil {
+				// The diff does not fit the model. Ask for the entire model.
+				c.resyncOn = true
+				c.resyncs++
+				c.lock.Unlock()
+				c.send(&invocation{Name: "goui:resync"})
+				continue
+			}
+			c.version = msg.Version
+			c.resyncOn = false
+			c.model = model
+			c.lock.Unlock()
+			c.send(&invocation{Name: "goui:ack", Message: []interface{}{msg.Version}})
+			if !c.gotModel {
+				c.gotModel = true
+				close(c.ready)
+			}
+		} else if msg.EventName != nil {
+			c.events.push(Event{Name: *msg.EventName, Data: msg.Event})
+		} else if msg.Func != nil {
+			c.calls.push(Call{Name: *msg.Func, Args: msg.Arguments, ID: msg.ID})
+		} else {
+			c.lock.Lock()
+			ch, ok := c.pending[msg.ID]
+			delete(c.pending, msg.ID)
+			c.lock.Unlock()
+			if !ok {
+				continue
+			}
+			if msg.Error != nil {
+				var e struct {
+					Code    string      `json:"code"`
+					Message string      `json:"message"`
+					Data    interface{} `json:"data"`
+				}
+				if err := json.Unmarshal(msg.Error, &e); err != nil {
+					e.Message = string(msg.Error)
+				}
+				ch <- &resultMessage{err: goui.NewError(e.Code, e.Message, e.Data)}
+			} else if msg.Arguments != nil {
+				data, _ := json.Marshal(msg.Arguments)
+				ch <- &resultMessage{value: data}
+			} else {
+				ch <- &resultMessage{value: msg.Value}
+			}
+		}
+	}
+}
+
+// Invoke calls the method `name` of the window's remote object and waits for its result.
+// If the method returns an error, Invoke returns a *goui.Error with the code, message and data
+// as seen by JavaScript.
+// If the method returns multiple values, `result` must point to a slice or array.
+// `result` can be nil if the return value is not of interest.
+// The model changes caused by the method call have been applied when Invoke returns.
+func (c *Client) Invoke(result interface{}, name string, args ...interface{}) error {
+	return c.InvokeContext(context.Background(), result, name, args...)
+}
+
+// InvokeContext is like Invoke. When `ctx` is done, InvokeContext asks the window
+// to cancel the call, just like aborting a call in JavaScript, and returns ctx.Err().
+func (c *Client) InvokeContext(ctx context.Context, result interface{}, name string, args ...interface{}) error {
+	if args == nil {
+		args = []interface{}{}
+	}
+	ch := make(chan *resultMessage, 1)
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return errors.New("gouitest: not connected")
+	}
+	c.counter++
+	id := c.counter
+	c.pending[id] = ch
+	c.lock.Unlock()
+
+	if err := c.send(&invocation{Name: name, Message: args, ID: id}); err != nil {
+		return err
+	}
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return r.err
+		}
+		if result == nil || r.value == nil {
+			return nil
+		}
+		return json.Unmarshal(r.value, result)
+	case <-ctx.Done():
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+		if err := c.send(&invocation{Name: "goui:cancel", ID: id}); err != nil {
+			return err
+		}
+		return ctx.Err()
+	case <-time.After(c.Timeout):
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+		return fmt.Errorf("gouitest: timeout while calling %v", name)
+	}
+}
+
+// Set assigns a value to a field of the model with the given `_id`, just like `go.set` in JavaScript.
+// The model changes caused by the patch have been applied when Set returns.
+func (c *Client) Set(id int, field string, value interface{}) error {
+	return c.Invoke(nil, "goui:patch", map[string]interface{}{"op": "set", "id": id, "f": field, "v": value})
+}
+
+// Splice modifies a slice field of the model with the given `_id`, just like `go.splice` in JavaScript.
+func (c *Client) Splice(id int, field string, start int, deleteCount int, items ...interface{}) error {
+	if items == nil {
+		items = []interface{}{}
+	}
+	return c.Invoke(nil, "goui:patch", map[string]interface{}{"op": "splice", "id": id, "f": field, "s": start, "d": deleteCount, "v": items})
+}
+
+// Fetch decodes the value of a field of the model with the given `_id` into `result`,
+// just like `go.fetch` in JavaScript.
+func (c *Client) Fetch(result interface{}, id int, field string) error {
+	return c.Invoke(result, "goui:fetch", map[string]interface{}{"id": id, "f": field})
+}
+
+// Undo asks the window to undo the latest modification of the model, just like `go.undo` in JavaScript.
+func (c *Client) Undo() error {
+	return c.Invoke(nil, "goui:undo")
+}
+
+// Redo asks the window to revert the latest Undo, just like `go.redo` in JavaScript.
+func (c *Client) Redo() error {
+	return c.Invoke(nil, "goui:redo")
+}
+
+func (c *Client) send(inv *invocation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	if c.Encoding() == goui.EncodingMsgPack {
+		msg, err := goui.JSONToMsgPack(data)
+		if err != nil {
+			return err
+		}
+		return websocket.Message.Send(c.conn, msg)
+	}
+	return websocket.Message.Send(c.conn, string(data))
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// Encoding returns the encoding the window picked for the websocket connection.
+// The Client receives all messages as JSON regardless of the encoding, i.e. binary
+// data in the model is a base64 string.
+func (c *Client) Encoding() goui.Encoding {
+	if p := c.conn.Config().Protocol; len(p) == 1 && p[0] == "goui.msgpack" {
+		return goui.EncodingMsgPack
+	}
+	return goui.EncodingJSON
+}
+
+// DropModelMessages discards the next `n` model messages, as if they had been lost.
+// The Client detects the gap when the next model message arrives and requests the
+// entire model, just like the browser does.
+func (c *Client) DropModelMessages(n int) {
+	c.lock.Lock()
+	c.drop += n
+	c.lock.Unlock()
+}
+
+// ModelVersion returns the version of the latest model message applied by the Client
+// and the number of times the Client requested the entire model.
+func (c *Client) ModelVersion() (version int, resyncs int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.version, c.resyncs
+}
+
+// Model returns a copy of the model as seen by the browser,
+// i.e. the JSON data available as `go.data` in JavaScript.
+func (c *Client) Model() interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return copyValue(c.model)
+}
+
+// DecodeModel decodes the model as seen by the browser into `v`.
+func (c *Client) DecodeModel(v interface{}) error {
+	c.lock.Lock()
+	data, err := json.Marshal(c.model)
+	c.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// NextEvent returns the oldest event that has not yet been returned.
+// It waits at most `timeout` for an event to arrive.
+func (c *Client) NextEvent(timeout time.Duration) (Event, error) {
+	v, err := c.events.pop(timeout)
+	if err != nil {
+		return Event{}, err
+	}
+	return v.(Event), nil
+}
+
+// NextCall returns the oldest JavaScript function call that has not yet been returned.
+// It waits at most `timeout` for a call to arrive.
+func (c *Client) NextCall(timeout time.Duration) (Call, error) {
+	v, err := c.calls.pop(timeout)
+	if err != nil {
+		return Call{}, err
+	}
+	return v.(Call), nil
+}
+
+// Return sends the result of a JavaScript function call to the window.
+func (c *Client) Return(call Call, value interface{}) error {
+	return c.send(&invocation{Name: "goui:return", Message: []interface{}{value}, ID: call.ID})
+}
+
+// Throw reports an exception thrown by a JavaScript function to the window.
+func (c *Client) Throw(call Call, message string) error {
+	return c.send(&invocation{Name: "goui:throw", Message: []interface{}{message}, ID: call.ID})
+}
+
+// Close behaves like closing the browser tab.
+// It returns after the window has terminated.
+func (c *Client) Close() error {
+	err := c.send(&invocation{Name: "goui:gui_terminated"})
+	if err != nil {
+		return err
+	}
+	c.window.Wait()
+	return c.conn.Close()
+}
+
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for key, val := range x {
+			m[key] = copyValue(val)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, val := range x {
+			arr[i] = copyValue(val)
+		}
+		return arr
+	}
+	return v
+}
+
+// queue is an unbounded FIFO, such that the reader never blocks
+// if the test does not consume events or calls.
+type queue struct {
+	lock   sync.Mutex
+	items  []interface{}
+	notify chan bool
+}
+
+func newQueue() *queue {
+	return &queue{notify: make(chan bool, 1)}
+}
+
+func (q *queue) push(v interface{}) {
+	q.lock.Lock()
+	q.items = append(q.items, v)
+	q.lock.Unlock()
+	select {
+	case q.notify <- true:
+	default:
+	}
+}
+
+func (q *queue) pop(timeout time.Duration) (interface{}, error) {
+	deadline := time.After(timeout)
+	for {
+		q.lock.Lock()
+		if len(q.items) > 0 {
+			v := q.items[0]
+			q.items = q.items[1:]
+			q.lock.Unlock()
+			return v, nil
+		}
+		q.lock.Unlock()
+		select {
+		case <-q.notify:
+		case <-deadline:
+			return nil, errors.New("gouitest: timeout")
+		}
+	}
+}
@@ -0,0 +1,1219 @@
+package gouitest_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/weistn/goui"
+	"github.com/weistn/goui/gouitest"
+)
+
+type itemModel struct {
+	goui.Model
+	Name string
+}
+
+type rootModel struct {
+	goui.Model
+	Count int
+	Items []*itemModel
+}
+
+type remote struct {
+	model     *rootModel
+	cancelled chan error
+}
+
+// Block waits until the call is cancelled
+func (r *remote) Block(ctx context.Context, tag string) error {
+	<-ctx.Done()
+	r.cancelled <- ctx.Err()
+	if goui.WindowFromContext(ctx) == nil {
+		return errors.New("no window")
+	}
+	return ctx.Err()
+}
+
+func (r *remote) Double(i int) int {
+	return 2 * i
+}
+
+func (r *remote) Split(i int) (int, int, error) {
+	if i < 0 {
+		return 0, 0, errors.New("negative")
+	}
+	return i / 2, i - i/2, nil
+}
+
+func (r *remote) Find(name string) (*itemModel, error) {
+	for _, item := range r.model.Items {
+		if item.Name == name {
+			return item, nil
+		}
+	}
+	return nil, goui.NewError("not_found", "no such item", name)
+}
+
+func (r *remote) Add(name string) {
+	r.model.Count++
+	r.model.Items = append(r.model.Items, &itemModel{Name: name})
+	r.model.ModelDirty()
+}
+
+func (r *remote) Rename(i int, name string) {
+	r.model.Items[i].Name = name
+	r.model.Items[i].ModelDirty()
+}
+
+func (r *remote) Remove(i int) {
+	r.model.Items = append(r.model.Items[:i], r.model.Items[i+1:]...)
+	r.model.ModelDirty()
+}
+
+func start(t *testing.T) (*goui.Window, *gouitest.Client, *remote) {
+	m := &rootModel{Items: []*itemModel{{Name: "a"}, {Name: "b"}}}
+	r := &remote{model: m, cancelled: make(chan error, 1)}
+	w := goui.NewWindow("/", r, m)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, c, r
+}
+
+func TestInvoke(t *testing.T) {
+	_, c, _ := start(t)
+	defer c.Close()
+
+	var i int
+	if err := c.Invoke(&i, "Double", 21); err != nil {
+		t.Fatal(err)
+	}
+	if i != 42 {
+		t.Fatalf("Expected 42, got %v", i)
+	}
+
+	var arr []int
+	if err := c.Invoke(&arr, "Split", 7); err != nil {
+		t.Fatal(err)
+	}
+	if len(arr) != 2 || arr[0] != 3 || arr[1] != 4 {
+		t.Fatalf("Unexpected result %v", arr)
+	}
+
+	if err := c.Invoke(nil, "Split", -1); err == nil || err.Error() != "negative" {
+		t.Fatalf("Expected error, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, c, _ := start(t)
+	defer c.Close()
+
+	code := func(err error) string {
+		var gerr goui.GouiError
+		if !errors.As(err, &gerr) {
+			t.Fatalf("Expected GouiError, got %v", err)
+		}
+		return gerr.ErrorCode()
+	}
+
+	err := c.Invoke(nil, "Find", "x")
+	if code(err) != "not_found" || err.(*goui.Error).Data != "x" {
+		t.Fatal(err)
+	}
+	if err = c.Invoke(nil, "NoSuchMethod"); code(err) != goui.ErrUnknownMethod {
+		t.Fatal(err)
+	}
+	if err = c.Invoke(nil, "Double", 1, 2); code(err) != goui.ErrParameterCount {
+		t.Fatal(err)
+	}
+	if err = c.Invoke(nil, "Double", "one"); code(err) != goui.ErrBadParameter {
+		t.Fatal(err)
+	}
+	// A plain error has no code
+	if err = c.Invoke(nil, "Split", -1); code(err) != "" || err.Error() != "negative" {
+		t.Fatal(err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	_, c, r := start(t)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.InvokeContext(ctx, nil, "Block", "slow")
+	}()
+
+	// The blocked call does not block other calls
+	var i int
+	if err := c.Invoke(&i, "Double", 2); err != nil || i != 4 {
+		t.Fatal(i, err)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("Expected cancellation, got %v", err)
+	}
+	select {
+	case err := <-r.cancelled:
+		if err != context.Canceled {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The context of the remote method has not been cancelled")
+	}
+}
+
+func TestSerialCalls(t *testing.T) {
+	_, c, r := start(t)
+	defer c.Close()
+
+	// Methods without a context modify the model one at a time
+	errs := make(chan error)
+	for i := 0; i < 20; i++ {
+		go func() {
+			errs <- c.Invoke(nil, "Add", "x")
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	var m rootModel
+	if err := c.DecodeModel(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Count != 20 || len(m.Items) != 22 || r.model.Count != 20 {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+}
+
+func TestModel(t *testing.T) {
+	_, c, _ := start(t)
+	defer c.Close()
+
+	var m rootModel
+	check := func(count int, names ...string) {
+		if err := c.DecodeModel(&m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Count != count || len(m.Items) != len(names) {
+			t.Fatalf("Unexpected model %v", c.Model())
+		}
+		for i, n := range names {
+			if m.Items[i].Name != n {
+				t.Fatalf("Unexpected model %v", c.Model())
+			}
+		}
+	}
+
+	check(0, "a", "b")
+	if err := c.Invoke(nil, "Add", "c"); err != nil {
+		t.Fatal(err)
+	}
+	check(1, "a", "b", "c")
+	if err := c.Invoke(nil, "Rename", 1, "B"); err != nil {
+		t.Fatal(err)
+	}
+	check(1, "a", "B", "c")
+	if err := c.Invoke(nil, "Remove", 0); err != nil {
+		t.Fatal(err)
+	}
+	check(1, "B", "c")
+}
+
+func TestEventsAndCalls(t *testing.T) {
+	w, c, _ := start(t)
+	defer c.Close()
+
+	// An event which cannot be marshalled does not block further events
+	if err := w.SendEvent("broken", make(chan int)); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err := w.SendEvent("greet", "Guten Tag"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Call("sayHello", "Joe Doe", 42); err != nil {
+		t.Fatal(err)
+	}
+	ev, err := c.NextEvent(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev.Name != "greet" || string(ev.Data) != `"Guten Tag"` {
+		t.Fatalf("Unexpected event %v %s", ev.Name, ev.Data)
+	}
+	call, err := c.NextCall(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Name != "sayHello" || len(call.Args) != 2 || string(call.Args[1]) != "42" {
+		t.Fatalf("Unexpected call %v", call)
+	}
+}
+
+type counterModel struct {
+	goui.Model
+	Count int
+}
+
+type appRemote struct {
+	app    *goui.App
+	models []*counterModel
+}
+
+// Increment increments the counters of all windows
+func (r *appRemote) Increment() {
+	for _, m := range r.models {
+		m.Count++
+		m.ModelDirty()
+	}
+}
+
+func TestApp(t *testing.T) {
+	r := &appRemote{models: []*counterModel{{}, {}}}
+	r.app = goui.NewApp(r)
+	w1, c1, err := gouitest.Open(r.app, "/", r.models[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	w2, c2, err := gouitest.Open(r.app, "/inspector", r.models[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w1.ID() == w2.ID() {
+		t.Fatal("Windows must have different IDs")
+	}
+
+	if err := c2.Invoke(nil, "Increment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.Invoke(nil, "Increment"); err != nil {
+		t.Fatal(err)
+	}
+	var m1, m2 counterModel
+	if err := c1.DecodeModel(&m1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.DecodeModel(&m2); err != nil {
+		t.Fatal(err)
+	}
+	if m1.Count != 2 || m2.Count != 2 {
+		t.Fatalf("Unexpected models %v %v", c1.Model(), c2.Model())
+	}
+
+	// Closing one window keeps the app running
+	if err := c1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Invoke(nil, "Increment"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r.app.Wait()
+}
+
+func TestInvokeJS(t *testing.T) {
+	w, c, _ := start(t)
+	defer c.Close()
+
+	done := make(chan error)
+	var ok bool
+	go func() {
+		done <- w.Invoke(context.Background(), &ok, "confirm", "Sure?")
+	}()
+	call, err := c.NextCall(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Name != "confirm" || call.ID == 0 {
+		t.Fatalf("Unexpected call %v", call)
+	}
+	if err := c.Return(call, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+
+	go func() {
+		done <- w.Invoke(context.Background(), nil, "canvasSize")
+	}()
+	if call, err = c.NextCall(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Throw(call, "no canvas"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil || err.Error() != "no canvas" {
+		t.Fatalf("Expected JSError, got %v", err)
+	}
+
+	// The browser does not answer in time
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := w.Invoke(ctx, nil, "selection"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected timeout, got %v", err)
+	}
+}
+
+type indexModel struct {
+	goui.Model
+	Items map[string]*itemModel
+	Tags  map[string]string
+}
+
+type indexRemote struct {
+	model *indexModel
+}
+
+func (r *indexRemote) Put(key string, name string) {
+	if item, ok := r.model.Items[key]; ok {
+		item.Name = name
+		item.ModelDirty()
+		return
+	}
+	r.model.Items[key] = &itemModel{Name: name}
+	r.model.ModelDirty()
+}
+
+func (r *indexRemote) Move(from string, to string) {
+	r.model.Items[to] = r.model.Items[from]
+	delete(r.model.Items, from)
+	r.model.Tags[to] = r.model.Tags[from]
+	delete(r.model.Tags, from)
+	r.model.ModelDirty()
+}
+
+func TestMapModel(t *testing.T) {
+	m := &indexModel{Items: map[string]*itemModel{"a": {Name: "A"}}, Tags: map[string]string{"a": "first"}}
+	c, err := gouitest.Start(goui.NewWindow("/", &indexRemote{model: m}, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	check := func(expected map[string]string, tags map[string]string) {
+		var m indexModel
+		if err := c.DecodeModel(&m); err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Items) != len(expected) || len(m.Tags) != len(tags) {
+			t.Fatalf("Unexpected model %v", c.Model())
+		}
+		for key, name := range expected {
+			if m.Items[key] == nil || m.Items[key].Name != name {
+				t.Fatalf("Unexpected model %v", c.Model())
+			}
+		}
+		for key, tag := range tags {
+			if m.Tags[key] != tag {
+				t.Fatalf("Unexpected model %v", c.Model())
+			}
+		}
+	}
+
+	check(map[string]string{"a": "A"}, map[string]string{"a": "first"})
+	if err := c.Invoke(nil, "Put", "b", "B"); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"a": "A", "b": "B"}, map[string]string{"a": "first"})
+	if err := c.Invoke(nil, "Put", "a", "AA"); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"a": "AA", "b": "B"}, map[string]string{"a": "first"})
+	if err := c.Invoke(nil, "Move", "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"c": "AA", "b": "B"}, map[string]string{"c": "first"})
+}
+
+type plainRemote struct {
+	model *rootModel
+}
+
+// Rename does not call ModelDirty
+func (r *plainRemote) Rename(i int, name string) {
+	r.model.Items[i].Name = name
+}
+
+func (r *plainRemote) Add(name string) {
+	r.model.Count++
+	r.model.Items = append(r.model.Items, &itemModel{Name: name})
+}
+
+func TestChangeDetection(t *testing.T) {
+	m := &rootModel{Items: []*itemModel{{Name: "a"}}}
+	w := goui.NewWindow("/", &plainRemote{model: m}, m)
+	w.SetChangeDetection(true)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Invoke(nil, "Add", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invoke(nil, "Rename", 0, "A"); err != nil {
+		t.Fatal(err)
+	}
+	var r rootModel
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 1 || len(r.Items) != 2 || r.Items[0].Name != "A" || r.Items[1].Name != "b" {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+}
+
+type tickRemote struct {
+	model *counterModel
+}
+
+// Tick modifies the model of the calling window
+func (r *tickRemote) Tick(ctx context.Context) {
+	goui.WindowFromContext(ctx).Update(func() {
+		r.model.Count++
+		r.model.ModelDirty()
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	m := &counterModel{}
+	w := goui.NewWindow("/", &tickRemote{model: m}, m)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A background worker and remote methods modify the model concurrently
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 50; i++ {
+			w.Update(func() {
+				m.Count++
+				m.ModelDirty()
+			})
+		}
+		done <- true
+	}()
+	for i := 0; i < 50; i++ {
+		if err := c.Invoke(nil, "Tick"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+	// The last update is synced once the next result arrives
+	if err := c.Invoke(nil, "Tick"); err != nil {
+		t.Fatal(err)
+	}
+	var r counterModel
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 101 {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+}
+
+func TestUpdateSerialCalls(t *testing.T) {
+	r := &appRemote{models: []*counterModel{{}, {}}}
+	r.app = goui.NewApp(r)
+	w1, c1, err := gouitest.Open(r.app, "/", r.models[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	_, c2, err := gouitest.Open(r.app, "/inspector", r.models[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	// A background worker modifies the model of one window, while the other window
+	// calls a method without a context.Context, which modifies the models of both windows
+	stop := make(chan bool)
+	updates := make(chan int)
+	go func() {
+		n := 0
+		for {
+			select {
+			case <-stop:
+				updates <- n
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+			w1.Update(func() {
+				r.models[0].Count++
+				r.models[0].ModelDirty()
+			})
+			n++
+		}
+	}()
+	for i := 0; i < 50; i++ {
+		if err := c2.Invoke(nil, "Increment"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	n := <-updates
+	if err := c1.Invoke(nil, "Increment"); err != nil {
+		t.Fatal(err)
+	}
+	var m counterModel
+	if err := c1.DecodeModel(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Count != n+51 {
+		t.Fatalf("Unexpected model %v", c1.Model())
+	}
+}
+
+func TestSyncInterval(t *testing.T) {
+	m := &counterModel{}
+	w := goui.NewWindow("/", &tickRemote{model: m}, m)
+	w.SetSyncInterval(10 * time.Millisecond)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A background worker reports progress without syncing
+	for i := 0; i < 100; i++ {
+		w.Update(func() {
+			m.Count++
+			m.ModelDirty()
+		})
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		var r counterModel
+		if err := c.DecodeModel(&r); err != nil {
+			t.Fatal(err)
+		}
+		if r.Count == 100 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The model has not been synced %v", c.Model())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type formModel struct {
+	goui.Model
+	Title   string
+	Created string `goui:"readonly"`
+	Items   []*itemModel
+}
+
+func TestPatch(t *testing.T) {
+	m := &formModel{Title: "Form", Created: "today", Items: []*itemModel{{Name: "a"}, {Name: "b"}}}
+	w := goui.NewWindow("/", &plainRemote{}, m)
+	changes := make(chan goui.ModelChange, 10)
+	w.OnModelChanged(func(change goui.ModelChange) {
+		changes <- change
+	})
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	code := func(err error) string {
+		var gerr goui.GouiError
+		if !errors.As(err, &gerr) {
+			t.Fatalf("Expected GouiError, got %v", err)
+		}
+		return gerr.ErrorCode()
+	}
+
+	if err := c.Set(m.ModelID(), "Title", "New Form"); err != nil {
+		t.Fatal(err)
+	}
+	if change := <-changes; m.Title != "New Form" || change.Model != m || change.Field != "Title" {
+		t.Fatalf("Unexpected change %v %v", m.Title, change)
+	}
+	if err := c.Set(m.Items[1].ModelID(), "Name", "B"); err != nil {
+		t.Fatal(err)
+	}
+	<-changes
+	if err := c.Splice(m.ModelID(), "Items", 0, 1, map[string]string{"Name": "c"}, map[string]string{"Name": "d"}); err != nil {
+		t.Fatal(err)
+	}
+	<-changes
+	var r formModel
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Title != "New Form" || len(r.Items) != 3 || r.Items[0].Name != "c" || r.Items[1].Name != "d" || r.Items[2].Name != "B" {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+
+	if err := c.Set(m.ModelID(), "Created", "tomorrow"); code(err) != goui.ErrReadOnly || m.Created != "today" {
+		t.Fatal(err)
+	}
+	if err := c.Set(m.ModelID(), "Title", 42); code(err) != goui.ErrBadPatch {
+		t.Fatal(err)
+	}
+	if err := c.Set(12345, "Title", "x"); code(err) != goui.ErrBadPatch {
+		t.Fatal(err)
+	}
+	if err := c.Splice(m.ModelID(), "Items", 2, 5); code(err) != goui.ErrBadPatch {
+		t.Fatal(err)
+	}
+}
+
+type quotedModel struct {
+	goui.Model
+	Pages int `json:",string"`
+	Count int
+}
+
+func TestPatchQuoted(t *testing.T) {
+	m := &quotedModel{Pages: 7}
+	w := goui.NewWindow("/", &plainRemote{}, m)
+	changes := make(chan goui.ModelChange, 10)
+	w.OnModelChanged(func(change goui.ModelChange) {
+		changes <- change
+	})
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// The browser holds the number as a string and sets it as a string
+	if data := c.Model().(map[string]interface{}); data["Pages"] != "7" || data["Count"] != 0.0 {
+		t.Fatalf("Unexpected model %v", data)
+	}
+	if err := c.Set(m.ModelID(), "Pages", "8"); err != nil {
+		t.Fatal(err)
+	}
+	<-changes
+	if m.Pages != 8 {
+		t.Fatalf("Unexpected model %+v", m)
+	}
+	if data := c.Model().(map[string]interface{}); data["Pages"] != "8" {
+		t.Fatalf("Unexpected model %v", data)
+	}
+	if err := c.Set(m.ModelID(), "Pages", 9); err == nil {
+		t.Fatal("Expected an error for an unquoted number")
+	}
+}
+
+type secretModel struct {
+	goui.Model
+	Title    string   `json:"title" goui:"caption"`
+	Password string   `goui:"-"`
+	History  []string `goui:"lazy"`
+	Notes    string   `goui:"notes,readonly"`
+}
+
+func TestTags(t *testing.T) {
+	m := &secretModel{Title: "Doc", Password: "secret", History: []string{"v1", "v2"}, Notes: "n"}
+	c, err := gouitest.Start(goui.NewWindow("/", &plainRemote{}, m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	data := c.Model().(map[string]interface{})
+	if data["caption"] != "Doc" || data["notes"] != "n" || len(data) != 3 {
+		t.Fatalf("Unexpected model %v", data)
+	}
+	var history []string
+	if err := c.Fetch(&history, m.ModelID(), "History"); err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[1] != "v2" {
+		t.Fatalf("Unexpected history %v", history)
+	}
+	if err := c.Fetch(nil, m.ModelID(), "Password"); err == nil {
+		t.Fatal("Excluded fields must not be fetched")
+	}
+	if err := c.Set(m.ModelID(), "notes", "x"); err == nil {
+		t.Fatal("notes is read-only")
+	}
+}
+
+func TestHistoryExcludedFields(t *testing.T) {
+	m := &secretModel{Title: "Doc", Password: "secret"}
+	w := goui.NewWindow("/", &plainRemote{}, m)
+	w.SetHistory(10)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// Undo restores the synced fields only
+	if err := w.Update(func() {
+		m.Title = "Doc 2"
+		m.Password = "changed"
+		m.ModelDirty()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Title != "Doc" || m.Password != "changed" {
+		t.Fatalf("Unexpected model %+v", m)
+	}
+	if data := c.Model().(map[string]interface{}); data["caption"] != "Doc" {
+		t.Fatalf("Unexpected model %v", data)
+	}
+}
+
+type imageModel struct {
+	goui.Model
+	Name  string
+	Image []byte
+	Scale float64
+}
+
+func TestMsgPack(t *testing.T) {
+	m := &imageModel{Name: "logo", Image: []byte{0, 1, 2, 255}, Scale: 1.5}
+	w := goui.NewWindow("/", &plainRemote{}, m)
+	w.SetEncoding(goui.EncodingMsgPack)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Encoding() != goui.EncodingMsgPack {
+		t.Fatal("Expected MessagePack")
+	}
+
+	var r imageModel
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "logo" || !bytes.Equal(r.Image, m.Image) || r.Scale != 1.5 {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+
+	// Binary data sent by the browser
+	if err := c.Set(m.ModelID(), "Image", []byte{7, 8}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Image, []byte{7, 8}) || !bytes.Equal(m.Image, []byte{7, 8}) {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+	var image []byte
+	if err := c.Fetch(&image, m.ModelID(), "Image"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(image, []byte{7, 8}) {
+		t.Fatalf("Unexpected image %v", image)
+	}
+}
+
+func TestDefaultEncoding(t *testing.T) {
+	_, c, _ := start(t)
+	defer c.Close()
+	if c.Encoding() != goui.EncodingJSON {
+		t.Fatal("Expected JSON")
+	}
+}
+
+type bigModel struct {
+	goui.Model
+	Values []int
+}
+
+func TestCompression(t *testing.T) {
+	m := &bigModel{Values: make([]int, 10000)}
+	for i := range m.Values {
+		m.Values[i] = i % 10
+	}
+	w := goui.NewWindow("/", &plainRemote{}, m)
+	w.SetCompression(1000)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var r bigModel
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Values) != 10000 || r.Values[9999] != 9 {
+		t.Fatal("Unexpected model")
+	}
+	stats := w.CompressionStats()
+	if stats.Messages != 1 || stats.Original <= 20000 || stats.Saved() <= 0 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	// Small messages are not compressed
+	if err := c.Invoke(nil, "Unknown"); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if w.CompressionStats() != stats {
+		t.Fatalf("Unexpected stats %+v", w.CompressionStats())
+	}
+}
+
+func TestModelVersion(t *testing.T) {
+	w, c, _ := start(t)
+	defer c.Close()
+
+	if err := c.Invoke(nil, "Add", "c"); err != nil {
+		t.Fatal(err)
+	}
+	// The acknowledgement arrives before the next call
+	if err := c.Invoke(nil, "Double", 1); err != nil {
+		t.Fatal(err)
+	}
+	if sent, acked := w.ModelVersion(); sent != 2 || acked != 2 {
+		t.Fatalf("Unexpected versions %v %v", sent, acked)
+	}
+
+	// Lose a model message. The next one reveals the gap.
+	c.DropModelMessages(1)
+	if err := c.Invoke(nil, "Rename", 0, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invoke(nil, "Add", "d"); err != nil {
+		t.Fatal(err)
+	}
+	// The entire model arrives before the next result
+	if err := c.Invoke(nil, "Double", 1); err != nil {
+		t.Fatal(err)
+	}
+	if version, resyncs := c.ModelVersion(); version != 5 || resyncs != 1 {
+		t.Fatalf("Unexpected version %v %v", version, resyncs)
+	}
+	var r rootModel
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Count != 2 || len(r.Items) != 4 || r.Items[0].Name != "A" || r.Items[3].Name != "d" {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+
+	// Diffs work after the resync
+	if err := c.Invoke(nil, "Rename", 3, "D"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Items[3].Name != "D" {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	w, c, _ := start(t)
+	defer c.Close()
+	// Reconnecting before the window noticed the closed websocket produces warnings
+	w.SetLogger(goui.NopLogger)
+
+	// Nothing has been missed
+	if err := c.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invoke(nil, "Double", 1); err != nil {
+		t.Fatal(err)
+	}
+	if sent, acked := w.ModelVersion(); sent != 1 || acked != 1 {
+		t.Fatalf("Unexpected versions %v %v", sent, acked)
+	}
+
+	// The lost model message is sent again
+	c.DropModelMessages(1)
+	if err := c.Invoke(nil, "Rename", 0, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invoke(nil, "Double", 1); err != nil {
+		t.Fatal(err)
+	}
+	if sent, acked := w.ModelVersion(); sent != 2 || acked != 2 {
+		t.Fatalf("Unexpected versions %v %v", sent, acked)
+	}
+	var r rootModel
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Items[0].Name != "A" {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+
+	// The buffered messages do not fit the new encoding, hence the entire model is sent
+	c.DropModelMessages(1)
+	if err := c.Invoke(nil, "Rename", 1, "B"); err != nil {
+		t.Fatal(err)
+	}
+	w.SetEncoding(goui.EncodingMsgPack)
+	if err := c.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invoke(nil, "Double", 1); err != nil {
+		t.Fatal(err)
+	}
+	if sent, acked := w.ModelVersion(); sent != 4 || acked != 4 {
+		t.Fatalf("Unexpected versions %v %v", sent, acked)
+	}
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Items) != 2 || r.Items[0].Name != "A" || r.Items[1].Name != "B" {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+	if _, resyncs := c.ModelVersion(); resyncs != 0 {
+		t.Fatal("Unexpected resync")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	w, c, r := start(t)
+	defer c.Close()
+	w.SetHistory(10)
+
+	if err := c.Invoke(nil, "Add", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invoke(nil, "Rename", 0, "A"); err != nil {
+		t.Fatal(err)
+	}
+	names := func() string {
+		var m rootModel
+		if err := c.DecodeModel(&m); err != nil {
+			t.Fatal(err)
+		}
+		var browser, server []string
+		for _, item := range m.Items {
+			browser = append(browser, item.Name)
+		}
+		for _, item := range r.model.Items {
+			server = append(server, item.Name)
+		}
+		if strings.Join(browser, ",") != strings.Join(server, ",") {
+			t.Fatalf("Browser %v and server %v differ", browser, server)
+		}
+		return strings.Join(server, ",")
+	}
+
+	if err := c.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if n := names(); n != "a,b,c" || r.model.Count != 1 {
+		t.Fatalf("Unexpected model %v", n)
+	}
+	if err := c.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if n := names(); n != "a,b" || r.model.Count != 0 {
+		t.Fatalf("Unexpected model %v", n)
+	}
+	if err := c.Undo(); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if err := c.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if n := names(); n != "a,b,c" {
+		t.Fatalf("Unexpected model %v", n)
+	}
+	if !w.CanRedo() || !w.CanUndo() {
+		t.Fatal("Expected undo and redo")
+	}
+
+	// A modification discards the redo steps
+	if err := c.Invoke(nil, "Rename", 2, "C"); err != nil {
+		t.Fatal(err)
+	}
+	if w.CanRedo() {
+		t.Fatal("Unexpected redo")
+	}
+	if err := c.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if n := names(); n != "a,b,c" {
+		t.Fatalf("Unexpected model %v", n)
+	}
+}
+
+func TestHistoryInterface(t *testing.T) {
+	m := &gridModel{Rows: [][]*itemModel{{{Name: "a"}, {Name: "b"}}, {{Name: "c"}}}}
+	w := goui.NewWindow("/", &gridRemote{model: m}, m)
+	w.SetChangeDetection(true)
+	w.SetHistory(10)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	a, cc := m.Rows[0][0], m.Rows[1][0]
+	id := cc.ModelID()
+
+	// The interface holds a slice of models
+	if err := c.Invoke(nil, "Hold", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Invoke(nil, "Rename", 0, 0, "A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if held, ok := m.Any.([]*itemModel); !ok || len(held) != 1 || held[0] != cc || cc.ModelID() != id {
+		t.Fatalf("Unexpected interface %#v", m.Any)
+	}
+	if m.Rows[0][0] != a || a.Name != "a" {
+		t.Fatalf("Unexpected model %v", a.Name)
+	}
+	if err := c.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Any != nil || len(m.Rows) != 2 || m.Rows[1][0] != cc {
+		t.Fatalf("Unexpected model %#v", m)
+	}
+	// The browser keeps the models, because their IDs have not changed
+	var r struct {
+		Rows [][]struct {
+			ID   int `json:"_id"`
+			Name string
+		}
+		Any interface{}
+	}
+	if err := c.DecodeModel(&r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Rows) != 2 || r.Rows[1][0].ID != id || r.Rows[0][0].Name != "a" || r.Any != nil {
+		t.Fatalf("Unexpected model %v", c.Model())
+	}
+	if err := c.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if held, ok := m.Any.([]*itemModel); !ok || len(held) != 1 || held[0] != cc {
+		t.Fatalf("Unexpected interface %#v", m.Any)
+	}
+}
+
+type gridModel struct {
+	goui.Model
+	Rows  [][]*itemModel
+	Slots [3]*itemModel
+	Any   interface{}
+}
+
+// gridRemote relies on change detection
+type gridRemote struct {
+	model *gridModel
+}
+
+func (r *gridRemote) Rename(row int, col int, name string) {
+	r.model.Rows[row][col].Name = name
+}
+
+func (r *gridRemote) AddRow(names []string) {
+	var row []*itemModel
+	for _, name := range names {
+		row = append(row, &itemModel{Name: name})
+	}
+	r.model.Rows = append(r.model.Rows, row)
+}
+
+func (r *gridRemote) Swap(i int, j int) {
+	r.model.Rows[i], r.model.Rows[j] = r.model.Rows[j], r.model.Rows[i]
+}
+
+func (r *gridRemote) Truncate(n int) {
+	r.model.Rows = r.model.Rows[:n]
+}
+
+// Slot moves the first model of a row to a slot
+func (r *gridRemote) Slot(slot int, row int) {
+	r.model.Slots[slot] = r.model.Rows[row][0]
+	r.model.Rows[row] = r.model.Rows[row][1:]
+}
+
+// Hold stores the models of a row in the interface field
+func (r *gridRemote) Hold(row int) {
+	r.model.Any = r.model.Rows[row]
+	r.model.Rows = append(r.model.Rows[:row], r.model.Rows[row+1:]...)
+}
+
+func TestGridModel(t *testing.T) {
+	m := &gridModel{Rows: [][]*itemModel{{{Name: "a"}, {Name: "b"}}, {{Name: "c"}}}}
+	w := goui.NewWindow("/", &gridRemote{model: m}, m)
+	w.SetChangeDetection(true)
+	c, err := gouitest.Start(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// names returns the names in the model as seen by the browser
+	names := func() string {
+		var r struct {
+			Rows  [][]*itemModel
+			Slots [3]*itemModel
+			Any   []*itemModel
+		}
+		if err := c.DecodeModel(&r); err != nil {
+			t.Fatal(err)
+		}
+		var rows []string
+		for _, row := range append(r.Rows, r.Slots[:], r.Any) {
+			var n []string
+			for _, item := range row {
+				if item == nil {
+					n = append(n, "-")
+				} else {
+					n = append(n, item.Name)
+				}
+			}
+			rows = append(rows, strings.Join(n, ","))
+		}
+		return strings.Join(rows, "|")
+	}
+	steps := []struct {
+		name     string
+		args     []interface{}
+		expected string
+	}{
+		{"Rename", []interface{}{0, 1, "B"}, "a,B|c|-,-,-|"},
+		{"AddRow", []interface{}{[]string{"d", "e"}}, "a,B|c|d,e|-,-,-|"},
+		{"Swap", []interface{}{0, 2}, "d,e|c|a,B|-,-,-|"},
+		{"Rename", []interface{}{2, 0, "A"}, "d,e|c|A,B|-,-,-|"},
+		{"Slot", []interface{}{2, 0}, "e|c|A,B|-,-,d|"},
+		{"Slot", []interface{}{0, 2}, "e|c|B|A,-,d|"},
+		{"Truncate", []interface{}{2}, "e|c|A,-,d|"},
+		{"Hold", []interface{}{1}, "e|A,-,d|c"},
+		{"Rename", []interface{}{0, 0, "E"}, "E|A,-,d|c"},
+	}
+	for _, s := range steps {
+		if err := c.Invoke(nil, s.name, s.args...); err != nil {
+			t.Fatal(err)
+		}
+		if n := names(); n != s.expected {
+			t.Fatalf("%v: expected %v, got %v", s.name, s.expected, n)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package goui
+
+import "reflect"
+
+// history records snapshots of a model for undo and redo.
+type history struct {
+	// limit is the number of undo steps. Zero disables the history.
+	limit int
+	// states are the snapshots of the model, oldest first
+	states []snapshot
+	// pos is the index of the current state
+	pos int
+}
+
+var modelType = reflect.TypeOf(Model{})
+
+// SetHistory enables undo and redo of modifications of the model with up to `limit` steps.
+// Each time the window syncs modifications, it records a copy of the fields of all models.
+// Thus, modifications that are synced together are undone together.
+// A limit of zero disables the history, which is the default.
+func (s *Window) SetHistory(limit int) {
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	s.history = history{limit: limit}
+	s.history.capture(s.model)
+}
+
+// Undo restores the model as it has been before the latest modification and syncs it.
+// In JavaScript, `go.undo()` does the same.
+// The models keep their identity, i.e. Undo restores the fields of the models which have been
+// part of the model at that time. The embedded Model, unexported fields and fields ignored by
+// encoding/json are left alone. Models which are stored by value, e.g. in a []T, are replaced
+// by new ones.
+// Undo must not be called by functions that hold the model lock, e.g. inside Update.
+func (s *Window) Undo() error {
+	return s.restore(-1)
+}
+
+// Redo reverts the latest Undo and syncs the model.
+// In JavaScript, `go.redo()` does the same.
+// Redo is no longer possible after the model has been modified otherwise.
+func (s *Window) Redo() error {
+	return s.restore(1)
+}
+
+// CanUndo returns true if Undo can restore a previous state of the model.
+func (s *Window) CanUndo() bool {
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	return s.history.pos > 0
+}
+
+// CanRedo returns true if Redo can revert an Undo.
+func (s *Window) CanRedo() bool {
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	return s.history.pos+1 < len(s.history.states)
+}
+
+// restore moves `step` states through the history.
+func (s *Window) restore(step int) error {
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	h := &s.history
+	pos := h.pos + step
+	if s.model == nil || pos < 0 || pos >= len(h.states) {
+		if step < 0 {
+			return NewError(ErrNoHistory, "nothing to undo", nil)
+		}
+		return NewError(ErrNoHistory, "nothing to redo", nil)
+	}
+	h.states[pos].restore()
+	h.pos = pos
+	// Syncing the restored model must not record a new state
+	h.states[pos] = takeSnapshot(s.model)
+	return s.syncModel()
+}
+
+// capture records the state of the model if it has changed.
+// The caller must hold the model lock.
+func (h *history) capture(model ModelIface) {
+	if h.limit <= 0 || model == nil {
+		return
+	}
+	state := takeSnapshot(model)
+	if len(h.states) > 0 {
+		if h.states[h.pos].equal(state) {
+			return
+		}
+		// Modifications discard the states that could be redone
+		h.states = h.states[:h.pos+1]
+	}
+	h.states = append(h.states, state)
+	if len(h.states) > h.limit+1 {
+		h.states = h.states[1:]
+	}
+	h.pos = len(h.states) - 1
+}
+
+// snapshot is a copy of the fields of all models in a tree of models.
+// It refers to the same models as the tree, i.e. restoring the snapshot keeps their identity.
+type snapshot map[ModelIface]reflect.Value
+
+// takeSnapshot copies the fields of `model` and of all models reachable from it.
+func takeSnapshot(model ModelIface) snapshot {
+	c := &copier{snapshot: make(snapshot)}
+	c.add(model)
+	return c.snapshot
+}
+
+// restore copies the fields of the snapshot back to the models.
+// The snapshot is not modified, such that it can be restored again.
+func (s snapshot) restore() {
+	c := &copier{snapshot: s}
+	for m, f := range s {
+		v := reflect.ValueOf(m).Elem()
+		for _, i := range historyFields(v.Type()) {
+			v.Field(i).Set(c.copy(f.Field(i)))
+		}
+		m.ModelDirty()
+	}
+}
+
+// equal returns true if both snapshots hold the same models with equal fields.
+func (s snapshot) equal(o snapshot) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for m, c := range s {
+		oc, ok := o[m]
+		if !ok || !reflect.DeepEqual(c.Interface(), oc.Interface()) {
+			return false
+		}
+	}
+	return true
+}
+
+// copier makes the deep copies of a snapshot.
+type copier struct {
+	snapshot snapshot
+	// ptrs maps pointers which are not models to their copies, such that
+	// a pointer which occurs several times is copied once.
+	ptrs map[ptrKey]reflect.Value
+}
+
+type ptrKey struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+// add copies the fields of the model `m` unless the snapshot holds them already.
+func (cp *copier) add(m ModelIface) {
+	if _, ok := cp.snapshot[m]; ok {
+		return
+	}
+	v := reflect.ValueOf(m).Elem()
+	c := reflect.New(v.Type()).Elem()
+	cp.snapshot[m] = c
+	for _, i := range historyFields(v.Type()) {
+		c.Field(i).Set(cp.copy(v.Field(i)))
+	}
+}
+
+// copy returns a deep copy of `v`. Models referenced by pointers are not copied,
+// but added to the snapshot. Models stored by value are copied without their embedded Model.
+func (cp *copier) copy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		if v.Type().Implements(modelIfaceType) {
+			cp.add(v.Interface().(ModelIface))
+			return v
+		}
+		key := ptrKey{v.Pointer(), v.Type()}
+		if c, ok := cp.ptrs[key]; ok {
+			return c
+		}
+		if cp.ptrs == nil {
+			cp.ptrs = make(map[ptrKey]reflect.Value)
+		}
+		c := reflect.New(v.Type().Elem())
+		cp.ptrs[key] = c
+		c.Elem().Set(cp.copy(v.Elem()))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(cp.copy(v.Elem()))
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(cp.copy(v.Index(i)))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(cp.copy(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), cp.copy(iter.Value()))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		if reflect.PtrTo(v.Type()).Implements(modelIfaceType) {
+			for _, i := range historyFields(v.Type()) {
+				c.Field(i).Set(cp.copy(v.Field(i)))
+			}
+			return c
+		}
+		// Unexported fields are shared with the copy
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				c.Field(i).Set(cp.copy(v.Field(i)))
+			}
+		}
+		return c
+	}
+	return v
+}
+
+// historyFields returns the indices of the fields of a model struct which undo and redo restore.
+// The embedded Model, unexported fields and fields which are not synced, i.e. fields
+// tagged `json:"-"` or `goui:"-"`, are left alone.
+func historyFields(t reflect.Type) []int {
+	var fields []int
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Type == modelType || sf.PkgPath != "" || sf.Tag.Get("json") == "-" {
+			continue
+		}
+		if name, _ := parseGouiTag(sf.Tag.Get("goui")); name == "-" {
+			continue
+		}
+		fields = append(fields, i)
+	}
+	return fields
+}
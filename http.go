@@ -1,15 +1,10 @@
 package goui
 
 import (
-	"crypto/rand"
-	"encoding/hex"
+	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"mime"
 	"net/http"
-	"net/http/httptest"
-	"strings"
 	"sync"
 	"time"
 
@@ -18,23 +13,55 @@ import (
 
 // Window opens a new browser window and handles the http
 // communication between the Go process and the browser window.
+// Each window belongs to an App. Multiple windows of the same App
+// share the HTTP server and the remote object.
 type Window struct {
+	app        *App
 	initalPath string
-	origin     string
-	token      []byte
-	cookie     string
-	// Incoming http requests are first checked for cookies etc. and
-	// then forwarded to this mux.
-	mux        *http.ServeMux
-	server     *httptest.Server
+	token      string
 	end        chan bool
+	endOnce    sync.Once
 	connected  chan bool
-	remote     interface{}
-	dispatcher *Dispatcher
 	model      ModelIface
 	modelState ModelState
-	lock       sync.Mutex
-	conn       *websocket.Conn
+	// modelLock serializes modifications of the model with syncing the model
+	modelLock sync.Mutex
+	lock      sync.Mutex
+	conn      *websocket.Conn
+	id        int
+	// connID counts the websocket connections of the window
+	connID int
+	// callCounter creates the IDs of calls started by Invoke
+	callCounter int
+	// pendingCalls are waiting for the result of a JS function
+	pendingCalls map[int]chan *callResult
+	// detectChanges is true if SyncModel calls DetectChanges
+	detectChanges bool
+	// notifier is the parent of the model
+	notifier        *syncNotifier
+	syncTrigger     chan struct{}
+	syncInterval    time.Duration
+	syncLoopRunning bool
+	// onModelChanged is called when the browser modified the model
+	onModelChanged func(change ModelChange)
+	// encoding is the preferred encoding of the websocket messages
+	encoding Encoding
+	// Messages larger than compressThreshold bytes are compressed
+	compressThreshold int
+	compressStats     CompressionStats
+	// version counts the model messages sent to the browser
+	version int
+	// ackedVersion is the latest version the browser has applied
+	ackedVersion int
+	// fullSync is true if the browser needs the entire model
+	fullSync bool
+	// unacked are the model messages the browser has not yet acknowledged
+	unacked      []modelMessage
+	unackedBytes int
+	// replay are the model messages to send again after a reconnect
+	replay []modelMessage
+	// history is guarded by the model lock
+	history history
 }
 
 // eventMessage is sent from server to client upon SendEvent
@@ -48,128 +75,94 @@ type eventMessage struct {
 type callMessage struct {
 	Arguments []interface{} `json:"a"`
 	Name      string        `json:"f"`
+	// ID is non-zero if the client must send the result of the call.
+	ID int `json:"id,omitempty"`
 }
 
-// NewWindow creates a new HTTP server.
+// callResult is the outcome of a JS function call started by Invoke.
+type callResult struct {
+	value json.RawMessage
+	err   error
+}
+
+// JSError is returned by Invoke if the JS function threw an exception.
+type JSError struct {
+	// Message is the message of the exception.
+	Message string
+}
+
+func (e *JSError) Error() string {
+	return e.Message
+}
+
+var windowCounter int32
+
+// NewWindow creates a new HTTP server with a single window.
 // Call Start() to run the server on a system-chosen port via
 // the loopback-device and to launch the UI in the browser.
 // The browser will open the `initialPath`, e.g. "/".
 // The functions of the `remote` interface can be called from JavaScript.
 // The `model` is synced to the browser, i.e. all changes made in GO are synced to the browser.
+// Use NewApp to create an application with multiple windows.
 func NewWindow(initialPath string, remote interface{}, model ModelIface) *Window {
-	// Make sure the server emits the right Content-Type header
-	mime.AddExtensionType(".css", "text/css")
-
-	// Create a token that is passed in the URL to the browser
-	token := make([]byte, 32)
-	n, err := rand.Reader.Read(token)
-	if n != len(token) || err != nil {
-		panic("No randomness here")
-	}
-	// The browser will exchange the token with a cookie
-	cookie := make([]byte, 32)
-	n, err = rand.Reader.Read(cookie)
-	if n != len(cookie) || err != nil {
-		panic("No randomness here")
-	}
+	return NewApp(remote).newWindow(initialPath, model)
+}
 
-	s := &Window{
-		mux:        http.NewServeMux(),
-		cookie:     hex.EncodeToString(cookie),
-		token:      token,
-		end:        make(chan bool),
-		connected:  make(chan bool),
-		remote:     remote,
-		dispatcher: NewDispatcher(remote),
-		model:      model,
-		initalPath: initialPath,
-	}
+// App returns the App that hosts the window.
+func (s *Window) App() *App {
+	return s.app
+}
 
-	ws := &websocket.Server{
-		Handshake: func(config *websocket.Config, r *http.Request) error { return s.handshake(config, r) },
-		Handler:   func(conn *websocket.Conn) { s.wshandler(conn) },
-	}
-	// WebSocket
-	s.mux.Handle("/_socket", ws)
-	// JavaScript code for RPC, events, model etc.
-	s.mux.HandleFunc("/_rpc.js", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/javascript")
-		w.Write([]byte(GenerateJSCode(s.remote)))
-	})
-	return s
+// ID returns a number that identifies the window in the process.
+func (s *Window) ID() int {
+	return s.id
 }
 
-// ServeHTTP handles incoming HTTP requests, checks authentication via the auth cookie
-// and checks for CSRF attacks via Referer and Origin header fields.
+// ServeHTTP serves HTTP requests of the window's App.
 // Do not call this function from the application code.
 func (s *Window) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	println("Request ...", r.URL.String(), r.RemoteAddr)
-	// The initial page has not yet been loaded?
-	if s.token != nil {
-		v := r.URL.Query()
-		// The first request must use the secret token and request "/"
-		if r.URL.Path == "/" && v.Get("token") == hex.EncodeToString(s.token) {
-			// s.token = nil
-			http.SetCookie(w, &http.Cookie{Name: "secret", Value: s.cookie})
-			// http.Redirect(w, r, "/foo", http.StatusSeeOther)
-			// Redirect the browser to the initial page and serve an auth cookie.
-			// On linux, xdg-open unfortunately requests the page itself and follows redirections.
-			// Thus, HTTP redirect will not do as it redirects xdg-open instead of the browser.
-			w.Header().Set("Content-Type", "text/html")
-			w.Write([]byte(fmt.Sprintf("<html><head><meta http-equiv=\"refresh\" content=\"0; url=%v%v\"></head><body></body></html>", s.server.URL, s.initalPath)))
-			return
-		} else if r.URL.Path == s.initalPath {
-			// The browser hit the landing page. From now on, do not hand out the cookie any more.
-			s.token = nil
-		} else if r.URL.Path == "/favicon.ico" {
-			// Ok ...
-		} else {
-			// println("Wrong initial request: ", r.URL.String())
-			// This is the wrong request. Must not happen before the initial page has been requested.
-			// Might be an attack. Stop the process.
-			s.close()
-			return
-		}
-	} else {
-		// println("Normal request ...", r.Method)
-		// println(r.RemoteAddr)
-
-		// CSRF prevention. Referer or Origin must be present and correct
-		referer := r.Header.Get("Referer")
-		origin := r.Header.Get("Origin")
-		if origin != s.origin && !strings.HasPrefix(referer, s.origin+"/") {
-			println("Wrong Referer and Origin")
-			w.WriteHeader(http.StatusUnauthorized)
-		}
-	}
-
-	// Authentication via cookie
-	c, err := r.Cookie("secret")
-	if c == nil || err != nil || c.Value != s.cookie {
-		println("Missing cookie")
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	}
+	s.app.ServeHTTP(w, r)
+}
 
-	// Now deliver content as requested
-	s.mux.ServeHTTP(w, r)
+func (s *Window) log() Logger {
+	return withAttrs(s.app.log, "window", s.id)
 }
 
+// close terminates the window.
+// The app terminates when its last window has been closed.
 func (s *Window) close() {
-	s.server.CloseClientConnections()
-	s.end <- true
-	s.server.Close()
+	s.terminate()
+	s.app.windowClosed(s)
+}
+
+// terminate signals the end of the window.
+func (s *Window) terminate() {
+	s.endOnce.Do(func() {
+		close(s.end)
+	})
 }
 
 func (s *Window) websocketConnected(conn *websocket.Conn) {
+	s.lock.Lock()
 	s.conn = conn
+	s.connID++
+	// Messages might have been lost with the previous connection
+	s.prepareReplay(conn)
+	s.lock.Unlock()
 	// if s.waitingForStart {
 	s.connected <- true
 	// }
 }
 
 func (s *Window) websocketDisconnected() {
+	s.lock.Lock()
 	s.conn = nil
+	// The results of pending calls will never arrive
+	for id, ch := range s.pendingCalls {
+		ch <- &callResult{err: errors.New("not connected")}
+		delete(s.pendingCalls, id)
+	}
+	s.lock.Unlock()
 
 	go func() {
 		select {
@@ -177,52 +170,70 @@ func (s *Window) websocketDisconnected() {
 			// Ok, do nothing
 			return
 		case <-time.After(10 * time.Second):
-			println("UI Timeout. No new websocket connection")
+			s.log().Error("UI timeout, no new websocket connection")
 			s.close()
 		}
 	}()
 }
 
-// Accept incoming websockets and allow for RPC
-func (s *Window) handshake(config *websocket.Config, r *http.Request) error {
+// handshake accepts an incoming websocket for the window
+func (s *Window) handshake(config *websocket.Config) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	if s.conn != nil {
-		println("Double websocket connection")
-		return errors.New("Unauthorized")
-	}
-	c, err := r.Cookie("secret")
-	if c == nil || err != nil || c.Value != s.cookie {
-		println("Illegal websocket connect")
+		s.log().Warn("double websocket connection")
 		return errors.New("Unauthorized")
 	}
-	// CSRF prevention
-	origin := r.Header.Get("Origin")
-	if origin != s.origin {
-		return errors.New("Unauthorized")
-	}
-
-	println("Websocket connect ok")
+	s.negotiate(config)
 	return nil
 }
 
 func (s *Window) wshandler(conn *websocket.Conn) {
 	s.websocketConnected(conn)
-	//    println("Websocket connected")
-	s.SyncModel()
+	log := withAttrs(s.log(), "conn", s.connID)
+	binary := isBinary(conn)
+	log.Info("websocket connected", "binary", binary)
+	// All calls are cancelled when the websocket closes
+	ctx, cancelAll := context.WithCancel(context.WithValue(context.Background(), windowKey{}, s))
+	defer cancelAll()
+	var callsLock sync.Mutex
+	calls := make(map[int]context.CancelFunc)
+	// reply sends the result of a call or patch.
+	// `diff` is true for the values of lazy fields, which fetch encodes for the connection.
+	reply := func(result []byte, diff bool) {
+		log.Debug("sending result", "data", string(result))
+		s.lock.Lock()
+		err := s.send(conn, result, diff)
+		s.lock.Unlock()
+		if err != nil {
+			log.Warn("websocket failed while sending", "err", err)
+			// Reading fails as well and handles the disconnect
+			conn.Close()
+		}
+	}
+	s.replayModel()
 	for {
-		var msg string
+		var msg []byte
 		err := websocket.Message.Receive(conn, &msg)
 		if err != nil {
-			println("Websocket failed while reading", err)
-			println(fmt.Sprintf("ERR %v %v\n", err.Error(), err))
+			log.Info("websocket failed while reading", "err", err)
 			s.websocketDisconnected()
 			// s.close()
 			return
 		}
-		println("Got data:", msg)
-		var inv invocation
-		err = json.Unmarshal([]byte(msg), &inv)
+		if binary {
+			if msg, err = MsgPackToJSON(msg); err != nil {
+				log.Warn("malformed request", "err", err)
+				continue
+			}
+		}
+		log.Debug("received", "data", string(msg))
+		inv, result, err := decodeInvocation(msg)
 		if err != nil {
-			println("Websocket got malformed request", err)
+			log.Warn("malformed request", "err", err)
+			if result != nil {
+				reply(result, false)
+			}
 			continue
 		}
 
@@ -232,34 +243,146 @@ func (s *Window) wshandler(conn *websocket.Conn) {
 			return
 		}
 
-		result, err := s.dispatcher.Dispatch(&inv)
+		// A JS function started by Invoke has completed?
+		if inv.Name == "goui:return" || inv.Name == "goui:throw" {
+			s.completeCall(&inv)
+			continue
+		}
 
-		s.SyncModel()
+		// The browser modified the model?
+		// Patches are applied in the order in which they arrive.
+		if inv.Name == "goui:patch" {
+			s.app.serialize(func() {
+				var result []byte
+				if err := s.patch(&inv); err != nil {
+					log.Warn("patch failed", "err", err)
+					result = errorResult(inv.ID, err)
+				} else {
+					result, _ = json.Marshal(&resultMessage{ID: inv.ID})
+				}
+				s.app.syncModels()
+				reply(result, false)
+			})
+			continue
+		}
 
-		s.lock.Lock()
-		if err != nil {
-			err = websocket.Message.Send(conn, `{"err": "Internal error"}`)
-		} else {
-			println("Sending:", string(result))
-			err = websocket.Message.Send(conn, string(result))
+		// The browser applied a model message?
+		if inv.Name == "goui:ack" {
+			s.ack(&inv)
+			continue
 		}
-		s.lock.Unlock()
-		if err != nil {
-			println("Websocket failed while sending", err)
-			s.websocketDisconnected()
-			// s.close()
-			return
+
+		// The browser missed a model message and requests the entire model?
+		if inv.Name == "goui:resync" {
+			log.Info("resync requested")
+			s.lock.Lock()
+			s.fullSync = true
+			s.lock.Unlock()
+			s.app.serialize(func() { s.SyncModel() })
+			continue
+		}
+
+		// The browser undoes or redoes a modification of the model?
+		if inv.Name == "goui:undo" || inv.Name == "goui:redo" {
+			s.app.serialize(func() {
+				var err error
+				if inv.Name == "goui:undo" {
+					err = s.Undo()
+				} else {
+					err = s.Redo()
+				}
+				result, _ := json.Marshal(&resultMessage{ID: inv.ID})
+				if err != nil {
+					log.Warn("undo/redo failed", "err", err)
+					result = errorResult(inv.ID, err)
+				}
+				reply(result, false)
+			})
+			continue
+		}
+
+		// The browser fetches a lazy field?
+		if inv.Name == "goui:fetch" {
+			s.app.serialize(func() {
+				result, err := s.fetch(&inv, binary)
+				if err != nil {
+					log.Warn("fetch failed", "err", err)
+					result = errorResult(inv.ID, err)
+				}
+				reply(result, true)
+			})
+			continue
+		}
+
+		// The browser aborted a call?
+		if inv.Name == "goui:cancel" {
+			log.Debug("cancel", "id", inv.ID)
+			callsLock.Lock()
+			if cancel, ok := calls[inv.ID]; ok {
+				cancel()
+			}
+			callsLock.Unlock()
+			continue
+		}
+
+		callCtx, cancel := context.WithCancel(ctx)
+		callsLock.Lock()
+		calls[inv.ID] = cancel
+		callsLock.Unlock()
+		dispatch := func() ([]byte, error) {
+			log.Debug("invoke", "method", inv.Name, "id", inv.ID)
+			result, err := s.app.dispatcher.DispatchContext(callCtx, &inv)
+			callsLock.Lock()
+			delete(calls, inv.ID)
+			callsLock.Unlock()
+			cancel()
+			return result, err
 		}
+		complete := func(result []byte, err error) {
+			// Sync the model of this window first and then all other windows,
+			// because the remote object is shared.
+			s.SyncModel()
+			s.app.syncModels()
+
+			if err != nil {
+				log.Warn("invoke failed", "method", inv.Name, "err", err)
+				result = errorResult(inv.ID, err)
+			}
+			reply(result, false)
+		}
+		if !s.app.dispatcher.Concurrent(inv.Name) {
+			// Run the method after all previous calls. It holds the model locks,
+			// such that it can modify the models without Update.
+			s.app.serialize(func() {
+				unlock := s.app.lockModels()
+				result, err := dispatch()
+				unlock()
+				complete(result, err)
+			})
+			continue
+		}
+		// Dispatch concurrently, such that slow calls do not block fast ones.
+		// The model is synced afterwards as if it has been modified by a serialized method.
+		go func() {
+			result, err := dispatch()
+			s.app.serialize(func() { complete(result, err) })
+		}()
 	}
 }
 
+// windowKey is the context key of the window that invoked a remote method.
+type windowKey struct{}
+
+// WindowFromContext returns the window that invoked a remote method.
+// `ctx` is the context passed to the remote method.
+// The result is nil if the context does not belong to a remote method call.
+func WindowFromContext(ctx context.Context) *Window {
+	s, _ := ctx.Value(windowKey{}).(*Window)
+	return s
+}
+
 // SendEvent sends an event to the browser
 func (s *Window) SendEvent(name string, event interface{}) error {
-	s.lock.Lock()
-	if s.conn == nil {
-		s.lock.Unlock()
-		return errors.New("not connected")
-	}
 	e := &eventMessage{
 		Event: event,
 		Name:  name,
@@ -268,11 +391,16 @@ func (s *Window) SendEvent(name string, event interface{}) error {
 	if err != nil {
 		return err
 	}
-	println("Sending", data)
-	err = websocket.Message.Send(s.conn, string(data))
+	s.lock.Lock()
+	if s.conn == nil {
+		s.lock.Unlock()
+		return errors.New("not connected")
+	}
+	s.log().Debug("sending event", "conn", s.connID, "data", string(data))
+	err = s.send(s.conn, data, false)
 	s.lock.Unlock()
 	if err != nil {
-		//        println("Websocket failed")
+		s.log().Warn("websocket failed while sending event", "conn", s.connID, "err", err)
 		s.close()
 		return err
 	}
@@ -281,8 +409,56 @@ func (s *Window) SendEvent(name string, event interface{}) error {
 
 // SyncModel synchronizes the client-side model with all changes
 // applied to the server-side model.
+// The window syncs the model after each remote method and each Update by itself.
+// SyncModel does not protect modifications of the model against concurrent syncs,
+// hence the model must be modified as described for Update.
 func (s *Window) SyncModel() error {
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	return s.syncModel()
+}
+
+// Update calls fn while holding the model lock of the window and syncs the model afterwards.
+// The model lock serializes modifications of the model with syncing the model.
+// The model must be modified either by remote methods without a context.Context, which are
+// called one at a time while holding the model locks of all windows, or inside Update.
+// This applies to background goroutines as well as to remote methods which accept a
+// context.Context and hence run concurrently with other calls.
+// Neither fn nor remote methods without a context.Context may call Update or SyncModel.
+// The error is the error of syncing the model, e.g. because the window is not connected.
+// The modifications are synced later in this case.
+// If background syncing is enabled via SetSyncInterval, Update schedules a sync and returns nil.
+func (s *Window) Update(fn func()) error {
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	fn()
+	s.lock.Lock()
+	background := s.syncInterval > 0
+	s.lock.Unlock()
+	if background {
+		s.scheduleSync()
+		return nil
+	}
+	return s.syncModel()
+}
+
+// syncModel synchronizes the model. The caller must hold the model lock.
+func (s *Window) syncModel() error {
 	s.lock.Lock()
+	if s.detectChanges && s.model != nil {
+		if err := DetectChanges(s.model); err != nil {
+			s.lock.Unlock()
+			return err
+		}
+	}
+	if s.fullSync && s.conn != nil {
+		// The browser receives the entire model. This assigns new IDs to all models.
+		if s.model != nil && s.model.ModelState() != ModelNew {
+			s.model.ModelTestSync(nil, nil)
+		}
+		s.modelState = ModelNew
+		s.fullSync = false
+	}
 	if (s.model == nil || s.model.ModelState() == ModelSynced) && s.modelState == ModelSynced {
 		s.lock.Unlock()
 		return nil
@@ -291,18 +467,28 @@ func (s *Window) SyncModel() error {
 		s.lock.Unlock()
 		return errors.New("not connected")
 	}
-	data, err := MarshalDiff(s.model)
+	full := s.model == nil || s.model.ModelState() == ModelNew
+	data, err := marshalDiff(s.model, s.notifier, isBinary(s.conn), nil)
 	if err != nil {
+		s.lock.Unlock()
 		return err
 	}
-	println("Sending Model", string(data))
-	err = websocket.Message.Send(s.conn, string(data))
+	s.version++
+	data = appendVersion(data, s.version, full)
+	s.remember(modelMessage{version: s.version, data: data, full: full, binary: isBinary(s.conn)})
+	s.log().Debug("sending model", "conn", s.connID, "data", string(data))
+	err = s.send(s.conn, data, true)
+	if err == nil {
+		s.modelState = ModelSynced
+	}
 	s.lock.Unlock()
+	// The model lock protects the model and its history, hence the window need not be locked
+	s.history.capture(s.model)
 	if err != nil {
+		s.log().Warn("websocket failed while sending model", "conn", s.connID, "err", err)
 		s.close()
 		return err
 	}
-	s.modelState = ModelSynced
 	return nil
 }
 
@@ -310,72 +496,144 @@ func (s *Window) SyncModel() error {
 // Call is async, i.e. it does not wait for the browser to complete the function call
 // and the result is not transmitted back to the server.
 func (s *Window) Call(fname string, args ...interface{}) error {
+	return s.call(&callMessage{
+		Arguments: args,
+		Name:      fname,
+	})
+}
+
+// Invoke calls the function `fname` in the browser and waits for its result.
+// If the function returns a promise, Invoke waits until the promise is settled.
+// The result is decoded into `result` as by json.Unmarshal, unless `result` is nil.
+// If the function throws an exception, Invoke returns a *JSError.
+// Invoke returns ctx.Err() if the context is done before the result has arrived.
+// Use context.WithTimeout to limit the waiting time.
+func (s *Window) Invoke(ctx context.Context, result interface{}, fname string, args ...interface{}) error {
+	ch := make(chan *callResult, 1)
 	s.lock.Lock()
-	if s.conn == nil {
-		s.lock.Unlock()
-		return errors.New("not connected")
+	s.callCounter++
+	id := s.callCounter
+	if s.pendingCalls == nil {
+		s.pendingCalls = make(map[int]chan *callResult)
 	}
-	c := &callMessage{
+	s.pendingCalls[id] = ch
+	s.lock.Unlock()
+
+	err := s.call(&callMessage{
 		Arguments: args,
 		Name:      fname,
+		ID:        id,
+	})
+	if err != nil {
+		s.lock.Lock()
+		delete(s.pendingCalls, id)
+		s.lock.Unlock()
+		return err
+	}
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return r.err
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(r.value, result)
+	case <-ctx.Done():
+		s.lock.Lock()
+		delete(s.pendingCalls, id)
+		s.lock.Unlock()
+		return ctx.Err()
+	case <-s.end:
+		return errors.New("window closed")
+	}
+}
+
+// completeCall delivers the result of a JS function to Invoke.
+func (s *Window) completeCall(inv *invocation) {
+	s.lock.Lock()
+	ch, ok := s.pendingCalls[inv.ID]
+	delete(s.pendingCalls, inv.ID)
+	s.lock.Unlock()
+	if !ok {
+		// Invoke is no longer waiting
+		return
+	}
+	r := &callResult{value: json.RawMessage("null")}
+	if len(inv.Message) > 0 {
+		r.value = inv.Message[0]
+	}
+	if inv.Name == "goui:throw" {
+		var msg string
+		if err := json.Unmarshal(r.value, &msg); err != nil {
+			msg = string(r.value)
+		}
+		r.err = &JSError{Message: msg}
+	}
+	ch <- r
+}
+
+func (s *Window) call(c *callMessage) error {
+	s.lock.Lock()
+	if s.conn == nil {
+		s.lock.Unlock()
+		return errors.New("not connected")
 	}
 	data, err := json.Marshal(c)
 	if err != nil {
 		s.lock.Unlock()
 		return err
 	}
-	println("Sending", data)
-	err = websocket.Message.Send(s.conn, string(data))
+	s.log().Debug("sending call", "conn", s.connID, "data", string(data))
+	err = s.send(s.conn, data, false)
 	s.lock.Unlock()
 	if err != nil {
-		//        println("Websocket failed")
+		s.log().Warn("websocket failed while sending call", "conn", s.connID, "err", err)
 		s.close()
 		return err
 	}
 	return nil
 }
 
+// Handle registers the handler for the given pattern.
+// If the window belongs to an App with multiple windows, the handler serves all of them.
 func (s *Window) Handle(pattern string, handler http.Handler) {
-	s.mux.Handle(pattern, handler)
+	s.app.Handle(pattern, handler)
+}
+
+// SetLogger configures the Logger of the window's App.
+// All messages of the window carry the attribute `window` and, if they relate to a
+// websocket connection, the attribute `conn`.
+// By default, the window uses DefaultLogger.
+// Use NopLogger to silence the window.
+func (s *Window) SetLogger(logger Logger) {
+	s.app.SetLogger(logger)
+}
+
+// SetLauncher configures how Start opens the UI.
+// By default, Start uses DefaultLauncher to open the operating system's default browser.
+// The launcher is configured for the window's App.
+func (s *Window) SetLauncher(launcher Launcher) {
+	s.app.SetLauncher(launcher)
+}
+
+// SetChangeDetection enables or disables automatic change detection.
+// If enabled, SyncModel calls DetectChanges on the model before syncing it.
+// Remote methods and functions passed to Update can then modify the model without calling ModelDirty,
+// because the window syncs its model after each of them.
+// By default, change detection is disabled.
+func (s *Window) SetChangeDetection(enabled bool) {
+	s.lock.Lock()
+	s.detectChanges = enabled
+	s.lock.Unlock()
 }
 
 // Start starts the web server and returns after the UI has
 // either been opened or if the UI could not be started.
+// Start must be called only once and only for windows created by NewWindow.
 func (s *Window) Start() error {
-	// Start accepting incoming HTTP requests
-	s.server = httptest.NewServer(s)
-
-	// Launch the browser
-	u := s.server.URL + "/?token=" + hex.EncodeToString(s.token)
-	s.origin = s.server.URL
-	println("URL", s.server.URL)
-	// cmd := exec.Command("open", u)
-	cmd := LaunchBrowser(u)
-	err := cmd.Start()
-	if err != nil {
-		return err
-	}
-	err = cmd.Wait()
-	if err != nil {
-		return err
-	}
-
-	// Wait for someone to connect in time.
-	// Otherwise close
-	select {
-	case <-s.connected:
-		//            println("UI has connected")
-		// Ok, do nothing
-	case <-time.After(15 * time.Second):
-		// Terminate the process, because the UI did not come up
-		s.server.Close()
-		return errors.New("UI could not be started")
-	case <-s.end:
-		// The server has been closed before a timeout and before
-		// a successfull connect happened
-		return errors.New("UI could not be started")
-	}
-	return nil
+	return s.app.open(s)
 }
 
 // Wait blocks until the user closed the browser window.
@@ -1,4 +1,4 @@
-window.go = (function() {
+{{ if .Module }}const go = {{ else }}window.go = {{ end }}(function() {
     var initPromise = null;
     var initFf = null;
     var initRej = null;
@@ -10,38 +10,315 @@ window.go = (function() {
     var gotModel = false;
     var queue = [];
     var reconnectCount = 0;
+    // The version of the latest model message applied to go.data
+    var version = 0;
+    var resyncRequested = false;
+    // The ID of the window is passed in the URL of the initial page.
+    // Remember it for the lifetime of the browser tab, such that the
+    // tab can navigate to other pages of the application.
+    var windowId = new URLSearchParams(window.location.search).get("goui_window");
+    if (windowId) {
+        sessionStorage.setItem("goui_window", windowId);
+    } else {
+        windowId = sessionStorage.getItem("goui_window");
+    }
 
     addEventListener("beforeunload", beforeUnload);
 
+    // RemoteError rejects the promise of a remote call if the Go function returned an error.
+    // `code` and `data` are set if the Go error implements goui.GouiError.
+    // Errors reported by goui itself have codes starting with "goui:".
+    class RemoteError extends Error {
+        constructor(e) {
+            super(e.message);
+            this.name = "RemoteError";
+            this.code = e.code;
+            this.data = e.data;
+        }
+    }
+
     // Signal the application process (if possible) that the UI is going away.
     // The application process will terminate when the UI is gone.
     function beforeUnload(ev) {
         if (connection) {
-            connection.send(JSON.stringify({n: "goui:gui_terminated"}))
+            connection.send(encode({n: "goui:gui_terminated"}))
         }
     }
 
-    function send(msg, ff, rej) {
+    // encode encodes a message in the format negotiated with the server.
+    function encode(msg) {
+        if (connection.protocol == "goui.msgpack") {
+            return encodeMsgPack(msg);
+        }
+        return JSON.stringify(msg);
+    }
+
+    // decode decodes a message received from the server.
+    // Large messages are compressed, which is signalled by a binary message starting with 0.
+    async function decode(data, protocol) {
+        if (typeof(data) === "string") {
+            return JSON.parse(data);
+        }
+        var bytes = new Uint8Array(data);
+        if (bytes[0] === 0) {
+            var stream = new Blob([bytes.subarray(1)]).stream().pipeThrough(new DecompressionStream("deflate"));
+            bytes = new Uint8Array(await new Response(stream).arrayBuffer());
+            if (protocol != "goui.msgpack") {
+                return JSON.parse(new TextDecoder().decode(bytes));
+            }
+        }
+        return decodeMsgPack(bytes);
+    }
+
+    // encodeMsgPack encodes a value as MessagePack, skipping undefined values like JSON.stringify.
+    // Uint8Arrays and ArrayBuffers are encoded as binary data.
+    function encodeMsgPack(value) {
+        var buf = new Uint8Array(256);
+        var view = new DataView(buf.buffer);
+        var pos = 0;
+        var textEncoder = new TextEncoder();
+        function reserve(n) {
+            if (pos + n <= buf.length) {
+                return;
+            }
+            var b = new Uint8Array(Math.max(buf.length * 2, pos + n));
+            b.set(buf);
+            buf = b;
+            view = new DataView(buf.buffer);
+        }
+        function byte(b) {
+            reserve(1);
+            buf[pos++] = b;
+        }
+        function header(n, fix, h16, h32) {
+            if (fix !== undefined && n < 16) {
+                byte(fix | n);
+            } else if (n <= 0xffff) {
+                reserve(3);
+                buf[pos++] = h16;
+                view.setUint16(pos, n);
+                pos += 2;
+            } else {
+                reserve(5);
+                buf[pos++] = h32;
+                view.setUint32(pos, n);
+                pos += 4;
+            }
+        }
+        function bytes(b) {
+            reserve(b.length);
+            buf.set(b, pos);
+            pos += b.length;
+        }
+        function number(v) {
+            if (Number.isInteger(v) && v >= -0x80000000 && v <= 0xffffffff) {
+                if (v >= 0 && v < 128 || v < 0 && v >= -32) {
+                    byte(v & 0xff);
+                } else if (v > 0) {
+                    reserve(5);
+                    buf[pos++] = 0xce;
+                    view.setUint32(pos, v);
+                    pos += 4;
+                } else {
+                    reserve(5);
+                    buf[pos++] = 0xd2;
+                    view.setInt32(pos, v);
+                    pos += 4;
+                }
+            } else {
+                reserve(9);
+                buf[pos++] = 0xcb;
+                view.setFloat64(pos, v);
+                pos += 8;
+            }
+        }
+        function write(v) {
+            if (v !== null && typeof(v) === "object" && typeof(v.toJSON) === "function") {
+                v = v.toJSON();
+            }
+            if (v === null || v === undefined) {
+                byte(0xc0);
+            } else if (v === false) {
+                byte(0xc2);
+            } else if (v === true) {
+                byte(0xc3);
+            } else if (typeof(v) === "number") {
+                number(v);
+            } else if (typeof(v) === "string") {
+                var s = textEncoder.encode(v);
+                if (s.length < 32) {
+                    byte(0xa0 | s.length);
+                } else if (s.length <= 0xff) {
+                    byte(0xd9);
+                    byte(s.length);
+                } else {
+                    header(s.length, undefined, 0xda, 0xdb);
+                }
+                bytes(s);
+            } else if (v instanceof Uint8Array || v instanceof ArrayBuffer) {
+                var b = new Uint8Array(v);
+                header(b.length, undefined, 0xc5, 0xc6);
+                bytes(b);
+            } else if (Array.isArray(v)) {
+                header(v.length, 0x90, 0xdc, 0xdd);
+                for (let e of v) {
+                    write(e);
+                }
+            } else {
+                var keys = Object.keys(v).filter((k) => v[k] !== undefined && typeof(v[k]) !== "function");
+                header(keys.length, 0x80, 0xde, 0xdf);
+                for (let k of keys) {
+                    write(k);
+                    write(v[k]);
+                }
+            }
+        }
+        write(value);
+        return buf.subarray(0, pos);
+    }
+
+    // decodeMsgPack decodes MessagePack. Binary data becomes a Uint8Array.
+    function decodeMsgPack(buf) {
+        var view = new DataView(buf.buffer, buf.byteOffset, buf.byteLength);
+        var pos = 0;
+        var textDecoder = new TextDecoder();
+        function uint(n) {
+            var v = 0;
+            for (let i = 0; i < n; i++) {
+                v = v * 256 + buf[pos++];
+            }
+            return v;
+        }
+        function str(n) {
+            var s = textDecoder.decode(buf.subarray(pos, pos + n));
+            pos += n;
+            return s;
+        }
+        function array(n) {
+            var arr = new Array(n);
+            for (let i = 0; i < n; i++) {
+                arr[i] = read();
+            }
+            return arr;
+        }
+        function map(n) {
+            var obj = {};
+            for (let i = 0; i < n; i++) {
+                var key = read();
+                obj[key] = read();
+            }
+            return obj;
+        }
+        function read() {
+            var c = buf[pos++];
+            if (c <= 0x7f) {
+                return c;
+            } else if (c >= 0xe0) {
+                return c - 0x100;
+            } else if ((c & 0xf0) == 0x80) {
+                return map(c & 0x0f);
+            } else if ((c & 0xf0) == 0x90) {
+                return array(c & 0x0f);
+            } else if ((c & 0xe0) == 0xa0) {
+                return str(c & 0x1f);
+            }
+            var v;
+            switch (c) {
+                case 0xc0: return null;
+                case 0xc2: return false;
+                case 0xc3: return true;
+                case 0xc4: case 0xc5: case 0xc6:
+                    var n = uint(1 << (c - 0xc4));
+                    v = buf.slice(pos, pos + n);
+                    pos += n;
+                    return v;
+                case 0xca: v = view.getFloat32(pos); pos += 4; return v;
+                case 0xcb: v = view.getFloat64(pos); pos += 8; return v;
+                case 0xcc: case 0xcd: case 0xce: case 0xcf:
+                    return uint(1 << (c - 0xcc));
+                case 0xd0: v = view.getInt8(pos); pos += 1; return v;
+                case 0xd1: v = view.getInt16(pos); pos += 2; return v;
+                case 0xd2: v = view.getInt32(pos); pos += 4; return v;
+                case 0xd3: v = Number(view.getBigInt64(pos)); pos += 8; return v;
+                case 0xd9: case 0xda: case 0xdb:
+                    return str(uint(1 << (c - 0xd9)));
+                case 0xdc: case 0xdd:
+                    return array(uint(2 << (c - 0xdc)));
+                case 0xde: case 0xdf:
+                    return map(uint(2 << (c - 0xde)));
+            }
+            throw new Error("Unsupported MessagePack type " + c);
+        }
+        return read();
+    }
+
+    function send(msg, ff, rej, signal) {
+        if (signal && signal.aborted) {
+            rej(abortReason(signal));
+            return;
+        }
         counter++;
-        msg.id = counter;
-        pending[counter] = {ff: ff, rej: rej};
-        if (connection) {
-            connection.send(JSON.stringify(msg));
+        var id = counter;
+        msg.id = id;
+        pending[id] = {ff: ff, rej: rej};
+        if (signal) {
+            // Ask the server to cancel the context of the call and reject immediately
+            signal.addEventListener("abort", function() {
+                var p = pending[id];
+                if (!p) {
+                    return;
+                }
+                delete pending[id];
+                sendRaw({n: "goui:cancel", id: id});
+                p.rej(abortReason(signal));
+            }, {once: true});
+        }
+        sendRaw(msg);
+    }
+
+    function sendRaw(msg) {
+        if (connection && connection.readyState == WebSocket.OPEN) {
+            connection.send(encode(msg));
         } else {
-            println("Queue")
-            queue.push(JSON.stringify(msg));
+            console.log("Queue");
+            // The encoding is known after the connection has been opened
+            queue.push(msg);
+        }
+    }
+
+    function abortReason(signal) {
+        if (signal.reason !== undefined) {
+            return signal.reason;
         }
+        return new DOMException("The call has been aborted", "AbortError");
     }
 
     function applyDiff(parent, prop, index, ins, diff) {
         var value
-        if (diff === null) {
-            value = null
+        if (diff === null || diff instanceof Uint8Array) {
+            value = diff
+        } else if (Array.isArray(diff)) {
+            // The value is an array literal
+            value = diff
         } else if (typeof(diff) === "object") {
+            // The value which is modified. An inserted array element has no value yet.
+            var target = index === undefined ? parent[prop] : (ins ? undefined : parent[index])
             if (diff._a !== undefined) {
-                // Modify an array
-                var arr = parent[prop]
-                var cloned = null
+                // Modify an array, which might be nested in another array
+                var arr = target
+                // Look up the models which move, before the array is modified
+                var moved = null
+                var k = 0
+                if (diff._k !== undefined) {
+                    var byID = new Map()
+                    for (let m of arr) {
+                        if (m !== null && !byID.has(m._id)) {
+                            byID.set(m._id, m)
+                        }
+                    }
+                    moved = diff._k.map(id => byID.get(id))
+                    k = moved.length
+                }
                 // Chop the array when necessary
                 if (arr.length != diff._l) {
                     arr.splice(diff._l, arr.length - diff._l)
@@ -52,25 +329,17 @@ window.go = (function() {
                     var e = diff._a[i]
                     if (typeof(e) === "number") {
                         pos -= e
-                    } else if (e._d !== undefined) {
-                        if (cloned === null) {
-                            cloned = [...arr]
-                        }
+                    } else if (e !== null && e._d !== undefined) {
                         pos -= e._d
                         arr.splice(pos, e._d)
-                    } else if (e._i !== undefined) {
+                    } else if (e !== null && e._i !== undefined) {
                         insertCount = e._i
-                    } else if (e._c !== undefined) {
-                        if (cloned === null) {
-                            cloned = [...arr]
-                        }
-                        arr.splice(pos, 0, ...(clone.slice(e._c, e._c + e._l)))
-                    } else if (e._t !== undefined) {
-                        if (cloned === null) {
-                            cloned = [...arr]
+                    } else if (e !== null && e._k !== undefined) {
+                        k -= e._k
+                        arr.splice(pos, 0, ...(moved.slice(k, k + e._k)))
+                        if (e._v !== undefined) {
+                            applyDiff(arr, undefined, pos, false, e._v)
                         }
-                        arr.splice(pos, 0, ...(clone.slice(e._c, e._c + e._l)))
-                        applyDiff(arr, undefined, pos, false, e._v)
                     } else {
                         if (insertCount > 0) {
                             applyDiff(arr, undefined, pos, true, e)
@@ -82,23 +351,53 @@ window.go = (function() {
                     }
                 }
                 return
+            } else if (diff._m !== undefined) {
+                // Modify a map
+                var obj = target
+                if (diff._n !== undefined || obj === null || typeof obj !== "object" || Array.isArray(obj)) {
+                    // The map has not been synced before, e.g. because it has been nil
+                    obj = {}
+                    if (index === undefined) {
+                        parent[prop] = obj
+                    } else {
+                        parent.splice(index, ins ? 0 : 1, obj)
+                    }
+                }
+                var old = Object.assign({}, obj)
+                if (diff._r !== undefined) {
+                    for (let key of diff._r) {
+                        delete obj[key]
+                    }
+                }
+                if (diff._c !== undefined) {
+                    for (let key of Object.keys(diff._c)) {
+                        obj[key] = old[diff._c[key]]
+                    }
+                }
+                if (diff._s !== undefined) {
+                    for (let key of Object.keys(diff._s)) {
+                        obj[key] = diff._s[key]
+                    }
+                }
+                for (let key of Object.keys(diff._m)) {
+                    applyDiff(obj, key, undefined, false, diff._m[key])
+                }
+                return
             } else if (diff._id !== undefined) {
                 // The value is an object literal
                 value = diff
             } else {
-                // Modify an object
-                for (let key of Object.keys(diff)) {
-                    if (index === undefined) {
-                        applyDiff(parent[prop], key, undefined, false, diff[key])
-                    } else {
-                        applyDiff(parent[index], key, undefined, false, diff[key])
+                if (target === null || typeof target !== "object" || Array.isArray(target)) {
+                    // The value is an object which is not a model, e.g. a struct which replaces nil
+                    value = diff
+                } else {
+                    // Modify an object
+                    for (let key of Object.keys(diff)) {
+                        applyDiff(target, key, undefined, false, diff[key])
                     }
+                    return
                 }
-                return
             }
-        } else if (Array.isArray(diff)) {
-            // The value is an array literal
-            value = diff
         } else {
             // The value is a primitive literal
             value = diff
@@ -161,6 +460,7 @@ window.go = (function() {
 
     var api = {
         data: null,
+        RemoteError: RemoteError,
         // The event "goui:process_terminated" is emitted by goui when the application process terminates.
         // The default is to remove the UI, but event listeners can keep the UI visible if desired.
         // All other events are emitted by the application process.
@@ -182,6 +482,40 @@ window.go = (function() {
                 }
             }
         },
+        // set assigns a value to a field of a model object in go.data.
+        // The Go model is modified and synced back, i.e. the promise resolves after
+        // go.data has been updated.
+        set: function(obj, field, value) {
+            return new Promise((ff, rej) => {
+                send({n: "goui:patch", v: [{op: "set", id: obj._id, f: field, v: value}]}, ff, rej);
+            });
+        },
+        // splice modifies an array field of a model object in go.data like Array.splice.
+        splice: function(obj, field, start, deleteCount, ...items) {
+            return new Promise((ff, rej) => {
+                send({n: "goui:patch", v: [{op: "splice", id: obj._id, f: field, s: start, d: deleteCount, v: items}]}, ff, rej);
+            });
+        },
+        // fetch returns the value of a field of a model object in go.data.
+        // Fields tagged `goui:"lazy"` are not synced and must be fetched instead.
+        fetch: function(obj, field) {
+            return new Promise((ff, rej) => {
+                send({n: "goui:fetch", v: [{id: obj._id, f: field}]}, ff, rej);
+            });
+        },
+        // undo restores the model as it has been before the latest modification.
+        // The history must be enabled with Window.SetHistory in Go.
+        undo: function() {
+            return new Promise((ff, rej) => {
+                send({n: "goui:undo", v: []}, ff, rej);
+            });
+        },
+        // redo reverts the latest undo.
+        redo: function() {
+            return new Promise((ff, rej) => {
+                send({n: "goui:redo", v: []}, ff, rej);
+            });
+        },
         connect: async function() {
             //if (initPromise) {
             //    return initPromise;
@@ -191,14 +525,28 @@ window.go = (function() {
                 initRej = rej;
             });
 
-            connection = new WebSocket('ws://' + window.location.host + "/_socket");
+            // The server picks the encoding of the messages
+            var url = 'ws://' + window.location.host + "/_socket?window=" + windowId;
+            if (typeof(DecompressionStream) !== "undefined") {
+                // The server may compress large messages
+                url += "&compress=1";
+            }
+            if (version > 0) {
+                // After a reconnect, the server sends the model messages we have missed
+                url += "&ver=" + version;
+            }
+            var conn = new WebSocket(url, ["goui.msgpack", "goui.json"]);
+            conn.binaryType = "arraybuffer";
+            connection = conn;
+            // Decoding is asynchronous. The chain of promises processes the messages in order.
+            var received = Promise.resolve();
 
             connection.onopen = function () {
                 console.log('WebSocket open');
                 reconnectCount = 0;
                 // Send queued data
                 while (queue && queue.length > 0) {
-                    connection.send(queue[0]);
+                    connection.send(encode(queue[0]));
                     queue.shift();
                 }
             };
@@ -222,49 +570,89 @@ window.go = (function() {
             };
 
             connection.onmessage = function (e) {
-                console.log('Server: ' + e.data);
-                var msg = JSON.parse(e.data);
-                if (msg.m !== undefined) {
-                    applyDiff(window.go, "data", undefined, false, msg.m)
-                    if (!gotModel) {
-                        // We are ready, because the initial model has been retrieved.
-                        gotModel = true
-                        initFf();
-                        initFf = null
-                        initRej = null
-                    }
-                } else if (msg.n !== undefined) {
-                    var arr = listeners[msg.n]
-                    if (arr) {
-                        for (var i = 0; i < arr.length; i++) {
-                            arr[i](msg.ev);
-                        }
-                    }
-                } else if (msg.f !== undefined) {
-                    if (!window[msg.f]) {
-                        console.log("Server is calling unknown function", msg.f);
-                        return;
-                    }
-                    window[msg.f].apply(null, msg.a);
-                } else {
-                    var p = pending[msg.id];
-                    if (!p) {
-                        console.log("Unexpected answer");
-                    }
-                    delete pending[msg.id];
-                    if (msg.e !== undefined) {
-                        p.rej(msg.e);
-                    } else if (msg.a !== undefined) {
-                        p.ff(msg.a);
-                    } else {
-                        p.ff(msg.v);
-                    }
-                }
+                received = received.then(() => decode(e.data, conn.protocol)).then(receive).catch((err) => {
+                    console.log("Failed to process message", err);
+                });
             };
             return initPromise;
         },
-        {{ . }}
+        {{ .API }}
     };
 
+    // receive processes a message received from the server.
+    function receive(msg) {
+        console.log('Server:', msg);
+        if (msg.m !== undefined) {
+            if (msg.ver !== undefined) {
+                if (!msg.full && msg.ver != version + 1) {
+                    // A model message is missing. Ask for the entire model.
+                    if (!resyncRequested) {
+                        console.log("Missing model version", version + 1);
+                        resyncRequested = true;
+                        sendRaw({n: "goui:resync"});
+                    }
+                    return;
+                }
+                version = msg.ver;
+                resyncRequested = false;
+            }
+            applyDiff(api, "data", undefined, false, msg.m)
+            if (msg.ver !== undefined) {
+                sendRaw({n: "goui:ack", v: [msg.ver]});
+            }
+            if (!gotModel) {
+                // We are ready, because the initial model has been retrieved.
+                gotModel = true
+                initFf();
+                initFf = null
+                initRej = null
+            }
+        } else if (msg.n !== undefined) {
+            var arr = listeners[msg.n]
+            if (arr) {
+                for (var i = 0; i < arr.length; i++) {
+                    arr[i](msg.ev);
+                }
+            }
+        } else if (msg.f !== undefined) {
+            if (!window[msg.f]) {
+                console.log("Server is calling unknown function", msg.f);
+                if (msg.id !== undefined) {
+                    sendRaw({n: "goui:throw", id: msg.id, v: ["Unknown function " + msg.f]});
+                }
+                return;
+            }
+            if (msg.id === undefined) {
+                window[msg.f].apply(null, msg.a);
+                return;
+            }
+            // The server waits for the result. The function may return a promise.
+            Promise.resolve().then(() => window[msg.f].apply(null, msg.a)).then((v) => {
+                sendRaw({n: "goui:return", id: msg.id, v: [v === undefined ? null : v]});
+            }, (e) => {
+                sendRaw({n: "goui:throw", id: msg.id, v: [e instanceof Error ? e.message : String(e)]});
+            });
+        } else {
+            var p = pending[msg.id];
+            if (!p) {
+                // The call might have been aborted
+                console.log("Unexpected answer");
+                return;
+            }
+            delete pending[msg.id];
+            if (msg.e !== undefined) {
+                p.rej(new RemoteError(msg.e));
+            } else if (msg.a !== undefined) {
+                p.ff(msg.a);
+            } else {
+                p.ff(msg.v);
+            }
+        }
+    }
+
     return api;
 })();
+{{ if .Module }}
+export { go };
+export default go;
+{{ end }}
\ No newline at end of file
@@ -0,0 +1,108 @@
+package goui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Launcher opens the UI of a Window.
+// Usually this means opening the URL in a browser.
+// The URL contains the secret token and must be treated confidentially.
+type Launcher interface {
+	Launch(url string) error
+}
+
+// TimeoutLauncher is implemented by launchers which decide how long OpenWindow waits
+// for the browser to connect. A timeout of zero waits until the window is closed.
+// Other launchers get 15 seconds.
+type TimeoutLauncher interface {
+	Launcher
+	ConnectTimeout() time.Duration
+}
+
+// connectTimeout is the time a window waits for the browser to connect,
+// unless the launcher is a TimeoutLauncher.
+var connectTimeout = 15 * time.Second
+
+// LauncherFunc adapts an ordinary function to the Launcher interface.
+type LauncherFunc func(url string) error
+
+// Launch calls f(url).
+func (f LauncherFunc) Launch(url string) error {
+	return f(url)
+}
+
+// DefaultLauncher opens the URL in the operating system's default browser.
+// It is used by windows that have no other Launcher configured.
+var DefaultLauncher Launcher = LauncherFunc(func(url string) error {
+	cmd := LaunchBrowser(url)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	return cmd.Wait()
+})
+
+// BrowserLauncher opens the URL with a specific browser executable.
+type BrowserLauncher struct {
+	// Path is the name or path of the browser executable, e.g. "chromium".
+	Path string
+	// Args are passed to the browser before the URL.
+	Args []string
+	// AppMode opens the URL in a window without tabs and address bar.
+	// AppMode is supported by Chromium based browsers only.
+	AppMode bool
+}
+
+// Launch starts the browser.
+// It does not wait for the browser process to terminate.
+func (b *BrowserLauncher) Launch(url string) error {
+	cmd := b.command(url)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	// Release the process resources once the browser terminates
+	go cmd.Wait()
+	return nil
+}
+
+func (b *BrowserLauncher) command(url string) *exec.Cmd {
+	args := append([]string{}, b.Args...)
+	if b.AppMode {
+		args = append(args, "--app="+url)
+	} else {
+		args = append(args, url)
+	}
+	return exec.Command(b.Path, args...)
+}
+
+// PrintLauncher does not open a browser. Instead it prints the URL,
+// such that the user can open it manually, e.g. in SSH sessions
+// with port forwarding.
+// The user might need a while to copy the URL, hence the window waits for the
+// browser without a timeout by default.
+type PrintLauncher struct {
+	// Writer receives the URL. If Writer is nil, the URL is printed to os.Stdout.
+	Writer io.Writer
+	// Timeout is the time to wait for the browser to connect. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// Launch prints the URL.
+func (p *PrintLauncher) Launch(url string) error {
+	w := p.Writer
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err := fmt.Fprintf(w, "Open %v in your browser\n", url)
+	return err
+}
+
+// ConnectTimeout implements TimeoutLauncher.
+func (p *PrintLauncher) ConnectTimeout() time.Duration {
+	return p.Timeout
+}
@@ -0,0 +1,67 @@
+package goui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBrowserLauncher(t *testing.T) {
+	b := &BrowserLauncher{Path: "chromium", Args: []string{"--incognito"}, AppMode: true}
+	cmd := b.command("http://127.0.0.1:1234/")
+	if strings.Join(cmd.Args, " ") != "chromium --incognito --app=http://127.0.0.1:1234/" {
+		t.Fatal(cmd.Args)
+	}
+	b.AppMode = false
+	cmd = b.command("http://127.0.0.1:1234/")
+	if strings.Join(cmd.Args, " ") != "chromium --incognito http://127.0.0.1:1234/" {
+		t.Fatal(cmd.Args)
+	}
+}
+
+func TestPrintLauncher(t *testing.T) {
+	var buf bytes.Buffer
+	p := &PrintLauncher{Writer: &buf}
+	if err := p.Launch("http://127.0.0.1:1234/"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "http://127.0.0.1:1234/") {
+		t.Fatal(buf.String())
+	}
+}
+
+func TestConnectTimeout(t *testing.T) {
+	defer func(d time.Duration) { connectTimeout = d }(connectTimeout)
+	connectTimeout = 10 * time.Millisecond
+	a := NewApp(&tsRemote{})
+	a.SetLogger(NopLogger)
+	defer a.close()
+
+	// A browser that does not connect in time
+	a.SetLauncher(LauncherFunc(func(url string) error { return nil }))
+	if err := a.open(a.newWindow("/", nil)); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+
+	// The user copies the URL printed by PrintLauncher and connects later
+	var buf bytes.Buffer
+	a.SetLauncher(&PrintLauncher{Writer: &buf})
+	w := a.newWindow("/", nil)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		w.connected <- true
+	}()
+	if err := a.open(w); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "token=") {
+		t.Fatal(buf.String())
+	}
+
+	// PrintLauncher with a timeout
+	a.SetLauncher(&PrintLauncher{Writer: &buf, Timeout: 10 * time.Millisecond})
+	if err := a.open(a.newWindow("/", nil)); err == nil {
+		t.Fatal("Expected a timeout")
+	}
+}
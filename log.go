@@ -0,0 +1,137 @@
+package goui
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the importance of a log message.
+// The values match the levels of log/slog.
+type Level int
+
+const (
+	// LevelDebug is used for tracing requests and messages.
+	// Debug messages may contain user data.
+	LevelDebug Level = -4
+	// LevelInfo is used for connection state changes.
+	LevelInfo Level = 0
+	// LevelWarn is used for rejected requests and connection failures.
+	LevelWarn Level = 4
+	// LevelError is used for failures that terminate a window.
+	LevelError Level = 8
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// Logger receives the log output of goui.
+// The methods follow the conventions of log/slog: `msg` is followed by
+// alternating keys and values. Thus, a *slog.Logger can be used as a Logger.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// NopLogger discards all log messages.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// NewLogger returns a Logger that writes all messages of at least `level`
+// to `w`. Each message is written as one line of key=value pairs.
+func NewLogger(w io.Writer, level Level) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+type textLogger struct {
+	lock  sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+func (l *textLogger) Debug(msg string, args ...interface{}) { l.log(LevelDebug, msg, args) }
+func (l *textLogger) Info(msg string, args ...interface{})  { l.log(LevelInfo, msg, args) }
+func (l *textLogger) Warn(msg string, args ...interface{})  { l.log(LevelWarn, msg, args) }
+func (l *textLogger) Error(msg string, args ...interface{}) { l.log(LevelError, msg, args) }
+
+func (l *textLogger) log(level Level, msg string, args []interface{}) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(time.Now().Format(time.RFC3339))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(quoteValue(msg))
+	for i := 0; i < len(args); i += 2 {
+		b.WriteByte(' ')
+		if i+1 == len(args) {
+			// A value without key
+			b.WriteString("!BADKEY=")
+			b.WriteString(quoteValue(fmt.Sprint(args[i])))
+			break
+		}
+		b.WriteString(fmt.Sprint(args[i]))
+		b.WriteByte('=')
+		b.WriteString(quoteValue(fmt.Sprint(args[i+1])))
+	}
+	b.WriteByte('\n')
+	l.lock.Lock()
+	io.WriteString(l.w, b.String())
+	l.lock.Unlock()
+}
+
+func quoteValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// attrLogger prepends attributes, e.g. the window ID, to all messages.
+type attrLogger struct {
+	logger Logger
+	attrs  []interface{}
+}
+
+// withAttrs returns a Logger that adds the key-value pairs in `attrs` to each message.
+func withAttrs(logger Logger, attrs ...interface{}) Logger {
+	if a, ok := logger.(*attrLogger); ok {
+		return &attrLogger{logger: a.logger, attrs: append(append([]interface{}{}, a.attrs...), attrs...)}
+	}
+	return &attrLogger{logger: logger, attrs: attrs}
+}
+
+func (l *attrLogger) args(args []interface{}) []interface{} {
+	return append(append([]interface{}{}, l.attrs...), args...)
+}
+
+func (l *attrLogger) Debug(msg string, args ...interface{}) { l.logger.Debug(msg, l.args(args)...) }
+func (l *attrLogger) Info(msg string, args ...interface{})  { l.logger.Info(msg, l.args(args)...) }
+func (l *attrLogger) Warn(msg string, args ...interface{})  { l.logger.Warn(msg, l.args(args)...) }
+func (l *attrLogger) Error(msg string, args ...interface{}) { l.logger.Error(msg, l.args(args)...) }
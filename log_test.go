@@ -0,0 +1,22 @@
+package goui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log := withAttrs(NewLogger(&buf, LevelInfo), "window", 1)
+	log.Debug("hidden", "data", "secret")
+	log = withAttrs(log, "conn", 2)
+	log.Warn("websocket failed", "err", "broken pipe")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one line, got %q", buf.String())
+	}
+	if !strings.HasSuffix(lines[0], ` level=WARN msg="websocket failed" window=1 conn=2 err="broken pipe"`) {
+		t.Fatal(lines[0])
+	}
+}
@@ -1,5 +1,7 @@
 package goui
 
+import "sync/atomic"
+
 // ModelState describes the synchronization state of a Model object
 type ModelState int
 
@@ -17,6 +19,10 @@ const (
 )
 
 // ModelIface is implemented by Model.
+// Models should embed Model, which keeps the bookkeeping of the sync, e.g. the
+// entries of map fields as they have been synced. Models which implement ModelIface
+// otherwise are synced without it, i.e. their maps, containers and interface fields
+// are sent entirely and change detection considers them modified each time.
 type ModelIface interface {
 	ModelDirty()
 	ModelChildDirty()
@@ -51,13 +57,136 @@ type Model struct {
 	parent ModelIface
 	index  int
 	id     int
+	// books records how the fields have been synced
+	books bookkeeping
+	// ids is the counter for assigning IDs. If nil, idCounter is used.
+	ids *int64
 }
 
-var idCounter int
+// idCounter is incremented atomically, because models of different windows
+// are synced concurrently.
+var idCounter int64
+
+// revisionCounter is incremented atomically to assign unique revisions.
+var revisionCounter uint64
+
+// bookkeeping records how the fields of a model have been synced the last time,
+// such that the next sync sends the changes only.
+// The methods of a nil bookkeeping record nothing, i.e. the browser is assumed
+// not to know the fields.
+type bookkeeping struct {
+	// maps records the entries of map fields, or nil if a map has been synced as null.
+	// For maps of models, the values are the IDs of the models.
+	// Otherwise, the values are the JSON encoding of the map values.
+	maps map[*Field]map[string]string
+	// containers records the containers, see containerRecord
+	containers map[*Field]containerRecord
+	// models records the IDs of the models held by interface fields
+	models map[*Field]int
+	// structs records whether pointers to plain structs have been synced as non-nil
+	structs map[*Field]bool
+	// paths are the paths of nested containers, see elemPath
+	paths map[elemPathKey]*Field
+	// hash of the fields as computed by DetectChanges
+	hash uint64
+	// revision of the fields, see currentRevision
+	revision uint64
+}
+
+// booksOf returns the bookkeeping of the Model embedded in `m`.
+// The result is nil if `m` does not embed Model.
+func booksOf(m ModelIface) *bookkeeping {
+	if e, ok := m.(interface{ gouiBooks() *bookkeeping }); ok {
+		return e.gouiBooks()
+	}
+	return nil
+}
+
+// swapMap returns the entries of the map field `field` as they have been synced the
+// last time and replaces them with `entries`. The entries are nil if the map has been
+// synced as null. The result is false if the map has not been synced.
+func (b *bookkeeping) swapMap(field *Field, entries map[string]string) (map[string]string, bool) {
+	if b == nil {
+		return nil, false
+	}
+	old, ok := b.maps[field]
+	if b.maps == nil {
+		b.maps = make(map[*Field]map[string]string)
+	}
+	b.maps[field] = entries
+	return old, ok
+}
+
+// swapStruct records whether the pointer to a plain struct in field `field` is synced
+// as non-nil and returns whether it has been synced as non-nil the last time.
+func (b *bookkeeping) swapStruct(field *Field, present bool) bool {
+	if b == nil {
+		return false
+	}
+	old := b.structs[field]
+	if !present {
+		delete(b.structs, field)
+		return old
+	}
+	if b.structs == nil {
+		b.structs = make(map[*Field]bool)
+	}
+	b.structs[field] = true
+	return old
+}
+
+// swapModel records that the interface field `field` holds the model with ID `id`
+// and returns the ID recorded the last time. The result is false if no model has been recorded.
+func (b *bookkeeping) swapModel(field *Field, id int) (int, bool) {
+	if b == nil {
+		return 0, false
+	}
+	old, ok := b.models[field]
+	if b.models == nil {
+		b.models = make(map[*Field]int)
+	}
+	b.models[field] = id
+	return old, ok
+}
+
+// forgetModel removes the record of the model held by the interface field `field`.
+func (b *bookkeeping) forgetModel(field *Field) {
+	if b != nil {
+		delete(b.models, field)
+	}
+}
+
+// hashChanged records the hash of the model's fields as computed by DetectChanges
+// and returns true if it differs from the hash recorded the last time.
+// Without bookkeeping, the result is always true.
+func (b *bookkeeping) hashChanged(hash uint64) bool {
+	if b == nil {
+		return true
+	}
+	changed := b.hash != hash
+	b.hash = hash
+	return changed
+}
+
+// currentRevision returns a number which identifies the current values of the model's fields.
+// ModelDirty assigns a new revision. Revisions are unique among all models,
+// hence a copy of a model struct has the same revision as the original only as long as
+// neither is modified.
+// Without bookkeeping, each call returns a new revision.
+func (b *bookkeeping) currentRevision() uint64 {
+	if b == nil {
+		return atomic.AddUint64(&revisionCounter, 1)
+	}
+	if b.revision == 0 {
+		b.revision = atomic.AddUint64(&revisionCounter, 1)
+	}
+	return b.revision
+}
 
 // ModelDirty marks the object as requiring synchronization.
 // The parent Models are automatically marked with ModelChildDirty.
 func (m *Model) ModelDirty() {
+	m.books.revision = atomic.AddUint64(&revisionCounter, 1)
 	if m.state == ModelSynced {
 		m.state = ModelDirty
 		if m.parent != nil {
@@ -105,8 +234,11 @@ func (m *Model) ModelTestSync(parent ModelIface, field *Field) ModelState {
 	} else if m.state == ModelNew {
 		m.parent = parent
 		m.field = field
-		m.id = idCounter
-		idCounter++
+		ids := m.ids
+		if ids == nil {
+			ids = &idCounter
+		}
+		m.id = int(atomic.AddInt64(ids, 1) - 1)
 	}
 	return m.state
 }
@@ -120,6 +252,12 @@ func (m *Model) ModelSwapIndex(index int) int {
 	return i
 }
 
+// gouiBooks returns the bookkeeping of the model, which makes it accessible
+// through the models which embed Model, see booksOf.
+func (m *Model) gouiBooks() *bookkeeping {
+	return &m.books
+}
+
 // ModelID returns a unique id for the model
 func (m *Model) ModelID() int {
 	return m.id
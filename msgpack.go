@@ -0,0 +1,468 @@
+package goui
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONToMsgPack transcodes a JSON document to MessagePack.
+// Integers become MessagePack integers and all other numbers become 64-bit floats.
+func JSONToMsgPack(data []byte) ([]byte, error) {
+	return jsonToMsgPack(data, false)
+}
+
+// jsonToMsgPack transcodes JSON to MessagePack. If `diff` is true, `data` may contain
+// byte slices encoded by an encodeState with `binary` set, which become binary data.
+// They are written as b"<base64>", which is not JSON and hence cannot collide with
+// the values of the model.
+func jsonToMsgPack(data []byte, diff bool) ([]byte, error) {
+	t := &msgPackTranscoder{data: data, diff: diff}
+	if err := t.value(); err != nil {
+		return nil, err
+	}
+	if t.skipSpace(); t.pos != len(t.data) {
+		return nil, errors.New("msgpack: trailing data after JSON value")
+	}
+	return t.out, nil
+}
+
+var errJSONShort = errors.New("msgpack: unexpected end of JSON")
+
+// msgPackTranscoder reads JSON from `data` starting at `pos` and appends MessagePack to `out`.
+// The JSON is read once.
+type msgPackTranscoder struct {
+	data []byte
+	pos  int
+	diff bool
+	out  []byte
+}
+
+func (t *msgPackTranscoder) skipSpace() {
+	for t.pos < len(t.data) {
+		switch t.data[t.pos] {
+		case ' ', '\t', '\n', '\r':
+			t.pos++
+		default:
+			return
+		}
+	}
+}
+
+// value transcodes the next JSON value.
+func (t *msgPackTranscoder) value() error {
+	if t.skipSpace(); t.pos >= len(t.data) {
+		return errJSONShort
+	}
+	switch c := t.data[t.pos]; {
+	case c == '{' || c == '[':
+		return t.elements(c)
+	case c == '"':
+		s, err := t.str()
+		if err == nil {
+			t.out = appendMsgPackString(t.out, s)
+		}
+		return err
+	case c == 'b' && t.diff:
+		t.pos++
+		if t.pos >= len(t.data) || t.data[t.pos] != '"' {
+			return errors.New("msgpack: malformed binary data")
+		}
+		s, err := t.str()
+		if err != nil {
+			return err
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return err
+		}
+		if len(data) <= math.MaxUint8 {
+			t.out = append(t.out, 0xc4, byte(len(data)))
+		} else {
+			t.out = appendMsgPackHeader(t.out, len(data), 0, 0xc5, 0xc6)
+		}
+		t.out = append(t.out, data...)
+		return nil
+	case c == '-' || '0' <= c && c <= '9':
+		start := t.pos
+		for t.pos < len(t.data) && strings.IndexByte("+-.0123456789eE", t.data[t.pos]) >= 0 {
+			t.pos++
+		}
+		var err error
+		t.out, err = appendMsgPackNumber(t.out, string(t.data[start:t.pos]))
+		return err
+	}
+	for _, lit := range []struct {
+		s string
+		b byte
+	}{{"null", 0xc0}, {"true", 0xc3}, {"false", 0xc2}} {
+		if bytes.HasPrefix(t.data[t.pos:], []byte(lit.s)) {
+			t.pos += len(lit.s)
+			t.out = append(t.out, lit.b)
+			return nil
+		}
+	}
+	return fmt.Errorf("msgpack: unexpected character %q in JSON", t.data[t.pos])
+}
+
+// elements transcodes an array or an object, which starts with `open`.
+// The number of elements precedes the elements in MessagePack. Hence the elements
+// are written behind room for the largest header, which is shrunk to the actual
+// header once the elements are known.
+func (t *msgPackTranscoder) elements(open byte) error {
+	start := len(t.out)
+	t.out = append(t.out, 0, 0, 0, 0, 0)
+	t.pos++
+	closing := byte(']')
+	if open == '{' {
+		closing = '}'
+	}
+	n := 0
+	for {
+		if t.skipSpace(); t.pos < len(t.data) && t.data[t.pos] == closing && n == 0 {
+			t.pos++
+			break
+		}
+		if open == '{' {
+			if t.skipSpace(); t.pos >= len(t.data) || t.data[t.pos] != '"' {
+				return errors.New("msgpack: object keys must be strings")
+			}
+			key, err := t.str()
+			if err != nil {
+				return err
+			}
+			t.out = appendMsgPackString(t.out, key)
+			if t.skipSpace(); t.pos >= len(t.data) || t.data[t.pos] != ':' {
+				return errors.New("msgpack: expected a colon in JSON object")
+			}
+			t.pos++
+		}
+		if err := t.value(); err != nil {
+			return err
+		}
+		n++
+		if t.skipSpace(); t.pos >= len(t.data) {
+			return errJSONShort
+		}
+		if c := t.data[t.pos]; c == closing {
+			t.pos++
+			break
+		} else if c != ',' {
+			return fmt.Errorf("msgpack: unexpected character %q in JSON", c)
+		}
+		t.pos++
+	}
+	var header []byte
+	if open == '[' {
+		header = appendMsgPackHeader(make([]byte, 0, 5), n, 0x90, 0xdc, 0xdd)
+	} else {
+		header = appendMsgPackHeader(make([]byte, 0, 5), n, 0x80, 0xde, 0xdf)
+	}
+	copy(t.out[start+len(header):], t.out[start+5:])
+	t.out = t.out[:len(t.out)-5+len(header)]
+	copy(t.out[start:], header)
+	return nil
+}
+
+// str reads a JSON string.
+func (t *msgPackTranscoder) str() (string, error) {
+	start := t.pos
+	escaped := false
+	for t.pos++; t.pos < len(t.data); t.pos++ {
+		switch t.data[t.pos] {
+		case '\\':
+			escaped = true
+			t.pos++
+		case '"':
+			t.pos++
+			if !escaped {
+				return string(t.data[start+1 : t.pos-1]), nil
+			}
+			var s string
+			err := json.Unmarshal(t.data[start:t.pos], &s)
+			return s, err
+		}
+	}
+	return "", errJSONShort
+}
+
+// appendMsgPackHeader appends the header of a value with `n` elements.
+// The header is `fix|n` for less than 16 elements, unless `fix` is zero.
+// Otherwise `h16` or `h32` is followed by the number of elements.
+func appendMsgPackHeader(b []byte, n int, fix, h16, h32 byte) []byte {
+	switch {
+	case fix != 0 && n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, h16, byte(n>>8), byte(n))
+	}
+	return append(b, h32, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+func appendMsgPackString(b []byte, s string) []byte {
+	switch {
+	case len(s) < 32:
+		b = append(b, 0xa0|byte(len(s)))
+	case len(s) <= math.MaxUint8:
+		b = append(b, 0xd9, byte(len(s)))
+	default:
+		b = appendMsgPackHeader(b, len(s), 0, 0xda, 0xdb)
+	}
+	return append(b, s...)
+}
+
+func appendMsgPackNumber(b []byte, n string) ([]byte, error) {
+	if !strings.ContainsAny(n, ".eE") {
+		if i, err := strconv.ParseInt(n, 10, 64); err == nil {
+			return appendMsgPackInt(b, i), nil
+		}
+		if u, err := strconv.ParseUint(n, 10, 64); err == nil {
+			b = append(b, 0xcf)
+			return appendUint64(b, u), nil
+		}
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, 0xcb)
+	return appendUint64(b, math.Float64bits(f)), nil
+}
+
+func appendMsgPackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(b, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return append(b, 0xcd, byte(i>>8), byte(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return append(b, 0xce, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	case i >= math.MinInt8 && i < 0:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i < 0:
+		return append(b, 0xd1, byte(i>>8), byte(i))
+	case i >= math.MinInt32 && i < 0:
+		return append(b, 0xd2, byte(i>>24), byte(i>>16), byte(i>>8), byte(i))
+	}
+	b = append(b, 0xd3)
+	return appendUint64(b, uint64(i))
+}
+
+// MsgPackToJSON transcodes a MessagePack document to JSON.
+// Binary data becomes a base64 encoded string, which json.Unmarshal decodes into a byte slice.
+// Map keys must be strings. Extension types are not supported.
+func MsgPackToJSON(data []byte) ([]byte, error) {
+	d := &msgPackDecoder{data: data}
+	e := newEncodeState()
+	defer encodeStatePool.Put(e)
+	if err := d.value(e); err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("msgpack: trailing data")
+	}
+	return append([]byte(nil), e.Bytes()...), nil
+}
+
+var errMsgPackShort = errors.New("msgpack: unexpected end of data")
+
+// msgPackDecoder reads MessagePack from `data` starting at `pos`.
+type msgPackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// read consumes the next n bytes.
+func (d *msgPackDecoder) read(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgPackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of n bytes.
+func (d *msgPackDecoder) uint(n int) (uint64, error) {
+	b, err := d.read(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// value writes the next MessagePack value as JSON to `e`.
+func (d *msgPackDecoder) value(e *encodeState) error {
+	b, err := d.read(1)
+	if err != nil {
+		return err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		e.WriteString(strconv.Itoa(int(c)))
+		return nil
+	case c >= 0xe0:
+		e.WriteString(strconv.Itoa(int(int8(c))))
+		return nil
+	case c&0xf0 == 0x80:
+		return d.mapValue(e, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return d.array(e, int(c&0x0f))
+	case c&0xe0 == 0xa0:
+		return d.str(e, int(c&0x1f))
+	}
+	switch c {
+	case 0xc0:
+		e.WriteString("null")
+	case 0xc2:
+		e.WriteString("false")
+	case 0xc3:
+		e.WriteString("true")
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return err
+		}
+		data, err := d.read(int(n))
+		if err != nil {
+			return err
+		}
+		e.WriteByte('"')
+		e.WriteString(base64.StdEncoding.EncodeToString(data))
+		e.WriteByte('"')
+	case 0xca:
+		u, err := d.uint(4)
+		if err != nil {
+			return err
+		}
+		return writeFloat(e, reflect.ValueOf(math.Float32frombits(uint32(u))))
+	case 0xcb:
+		u, err := d.uint(8)
+		if err != nil {
+			return err
+		}
+		return writeFloat(e, reflect.ValueOf(math.Float64frombits(u)))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		u, err := d.uint(1 << (c - 0xcc))
+		if err != nil {
+			return err
+		}
+		e.WriteString(strconv.FormatUint(u, 10))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return err
+		}
+		// Sign extension
+		shift := 64 - 8*size
+		e.WriteString(strconv.FormatInt(int64(u<<shift)>>shift, 10))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return err
+		}
+		return d.str(e, int(n))
+	case 0xdc, 0xdd:
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return err
+		}
+		return d.array(e, int(n))
+	case 0xde, 0xdf:
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return err
+		}
+		return d.mapValue(e, int(n))
+	default:
+		return fmt.Errorf("msgpack: unsupported type 0x%x", c)
+	}
+	return nil
+}
+
+func (d *msgPackDecoder) str(e *encodeState, n int) error {
+	s, err := d.read(n)
+	if err != nil {
+		return err
+	}
+	e.stringBytes(s, false)
+	return nil
+}
+
+func (d *msgPackDecoder) array(e *encodeState, n int) error {
+	e.WriteByte('[')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			e.WriteByte(',')
+		}
+		if err := d.value(e); err != nil {
+			return err
+		}
+	}
+	e.WriteByte(']')
+	return nil
+}
+
+func (d *msgPackDecoder) mapValue(e *encodeState, n int) error {
+	e.WriteByte('{')
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			e.WriteByte(',')
+		}
+		b, err := d.read(1)
+		if err != nil {
+			return err
+		}
+		c := b[0]
+		switch {
+		case c&0xe0 == 0xa0:
+			err = d.str(e, int(c&0x1f))
+		case c >= 0xd9 && c <= 0xdb:
+			var n uint64
+			if n, err = d.uint(1 << (c - 0xd9)); err == nil {
+				err = d.str(e, int(n))
+			}
+		default:
+			err = errors.New("msgpack: map keys must be strings")
+		}
+		if err != nil {
+			return err
+		}
+		e.WriteByte(':')
+		if err := d.value(e); err != nil {
+			return err
+		}
+	}
+	e.WriteByte('}')
+	return nil
+}
+
+// writeFloat writes a float like encoding/json does, i.e. integral values have no exponent
+// and can be decoded into integers.
+func writeFloat(e *encodeState, v reflect.Value) error {
+	if math.IsInf(v.Float(), 0) || math.IsNaN(v.Float()) {
+		return errors.New("msgpack: unsupported float value")
+	}
+	floatEncoder(v.Type().Bits()).encode(e, v, encOpts{})
+	return nil
+}
+
+func appendUint64(b []byte, u uint64) []byte {
+	return append(b, byte(u>>56), byte(u>>48), byte(u>>40), byte(u>>32), byte(u>>24), byte(u>>16), byte(u>>8), byte(u))
+}
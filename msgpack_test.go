@@ -0,0 +1,125 @@
+package goui
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestMsgPack(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	tests := []string{
+		`null`, `true`, `false`, `0`, `127`, `128`, `-32`, `-33`, `-200`, `70000`, `-70000`,
+		`4294967296`, `-4294967296`, `18446744073709551615`, `1.5`, `-0.25`, `1e+21`,
+		`""`, `"hello"`, `"` + long + `"`, `"ä\"\\"`,
+		`[]`, `[1,"a",null,[true]]`, `{}`, `{"a":1,"b":{"c":[1,2]}}`,
+		`[0,1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17]`,
+	}
+	for _, test := range tests {
+		data, err := JSONToMsgPack([]byte(test))
+		if err != nil {
+			t.Fatalf("%v: %v", test, err)
+		}
+		result, err := MsgPackToJSON(data)
+		if err != nil {
+			t.Fatalf("%v: %v", test, err)
+		}
+		if string(result) != test {
+			t.Fatalf("Expected %v, got %v", test, string(result))
+		}
+	}
+
+	// Headers of all sizes nested in each other
+	elems := make([]string, 70000)
+	for i := range elems {
+		elems[i] = fmt.Sprintf(`{"a":[%v,%v]}`, i, strings.Repeat(`0,`, i%20)+"1")
+	}
+	big := "[" + strings.Join(elems, ",") + "]"
+	data, err := JSONToMsgPack([]byte(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != 0xdd {
+		t.Fatalf("Expected a 32-bit array header, got %x", data[0])
+	}
+	if result, err := MsgPackToJSON(data); err != nil || string(result) != big {
+		t.Fatalf("Unexpected result %v", err)
+	}
+
+	// Binary data is only recognized in model diffs
+	data, err = jsonToMsgPack([]byte(`{"img":b"AQID"}`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x81, 0xa3, 'i', 'm', 'g', 0xc4, 3, 1, 2, 3}; !bytes.Equal(data, expected) {
+		t.Fatalf("Expected %x, got %x", expected, data)
+	}
+	result, err := MsgPackToJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result) != `{"img":"AQID"}` {
+		t.Fatalf("Unexpected %v", string(result))
+	}
+	if _, err := JSONToMsgPack([]byte(`{"img":b"AQID"}`)); err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// Malformed JSON
+	for _, test := range []string{``, `[1,`, `[1,]`, `[1 2]`, `{"a"}`, `{1:2}`, `nul`, `"abc`, `1 2`, `-`} {
+		if _, err := JSONToMsgPack([]byte(test)); err == nil {
+			t.Fatalf("Expected an error for %v", test)
+		}
+	}
+
+	// Malformed MessagePack
+	if _, err := MsgPackToJSON([]byte{0x92, 1}); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, err := MsgPackToJSON([]byte{0x81, 1, 2}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+type BinaryModel struct {
+	Model
+	Image []byte
+	Meta  map[string]interface{}
+}
+
+func TestBinaryDiff(t *testing.T) {
+	// Meta looks like binary data in JSON, but it is not
+	m := &BinaryModel{Image: []byte{1, 2, 3}, Meta: map[string]interface{}{"_b": "AQID"}}
+	data, err := marshalDiff(m, nil, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Image":b"AQID"`) {
+		t.Fatalf("Unexpected %v", string(data))
+	}
+	msg, err := jsonToMsgPack(data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := MsgPackToJSON(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		M struct {
+			Image []byte
+			Meta  map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(result, &v); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.M.Image, m.Image) || v.M.Meta["_b"] != "AQID" {
+		t.Fatalf("Unexpected %v", string(result))
+	}
+	if !bytes.Contains(msg, []byte{0xc4, 3, 1, 2, 3}) || bytes.Count(msg, []byte{0xc4}) != 1 {
+		t.Fatalf("Unexpected %x", msg)
+	}
+}
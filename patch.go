@@ -0,0 +1,235 @@
+package goui
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// patchMessage is sent by the browser to modify or fetch a field of a model.
+// `ID` is the `_id` of the model as seen by the browser and `Field` is the name of the field in JSON.
+// The operation "set" assigns `Value` to the field.
+// The operation "splice" removes `Delete` elements of a slice at position `Start` and inserts
+// the elements of `Value` instead, just like Array.splice in JavaScript.
+type patchMessage struct {
+	Op     string          `json:"op"`
+	ID     int             `json:"id"`
+	Field  string          `json:"f"`
+	Value  json.RawMessage `json:"v"`
+	Start  int             `json:"s"`
+	Delete int             `json:"d"`
+}
+
+// ModelChange describes a modification of the model made by the browser.
+type ModelChange struct {
+	// Model is the model that contains the modified field.
+	Model ModelIface
+	// Field is the name of the field as seen by JavaScript.
+	Field string
+}
+
+// OnModelChanged registers a function which is called after the browser has modified the model
+// via `go.set` or `go.splice`.
+// The function is called while the window holds the model lock. Thus, it can modify the model,
+// but must not call Update or SyncModel. The model is synced after the function returns.
+// Fields tagged `goui:"readonly"` cannot be modified by the browser.
+func (s *Window) OnModelChanged(fn func(change ModelChange)) {
+	s.modelLock.Lock()
+	s.onModelChanged = fn
+	s.modelLock.Unlock()
+}
+
+// patch applies a patch sent by the browser and syncs the model.
+func (s *Window) patch(inv *invocation) error {
+	if len(inv.Message) != 1 {
+		return NewError(ErrParameterCount, "goui:patch expects one parameter", nil)
+	}
+	var p patchMessage
+	if err := json.Unmarshal(inv.Message[0], &p); err != nil {
+		return NewError(ErrBadPatch, err.Error(), nil)
+	}
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	m, err := s.applyPatch(&p)
+	if err != nil {
+		return err
+	}
+	m.ModelDirty()
+	if s.onModelChanged != nil {
+		s.onModelChanged(ModelChange{Model: m, Field: p.Field})
+	}
+	return s.syncModel()
+}
+
+// applyPatch modifies the model as requested by `p` and returns the modified model.
+// The caller must hold the model lock.
+func (s *Window) applyPatch(p *patchMessage) (ModelIface, error) {
+	m, f, fv, err := s.lookupField(p.ID, p.Field)
+	if err != nil {
+		return nil, err
+	}
+	if f.readonly {
+		return nil, NewError(ErrReadOnly, fmt.Sprintf("field %v is read-only", p.Field), p.Field)
+	}
+
+	switch p.Op {
+	case "set":
+		if f.isModel || f.isModelPtr || f.isModelContainer || f.isModelMapPtr {
+			return nil, NewError(ErrBadPatch, fmt.Sprintf("field %v contains models and cannot be set", p.Field), p.Field)
+		}
+		data := []byte(p.Value)
+		if f.quoted {
+			// The value is encoded inside a JSON string like the diff sends it,
+			// see the option "string" of encoding/json
+			var s string
+			if err := json.Unmarshal(data, &s); err != nil {
+				return nil, NewError(ErrBadPatch, err.Error(), p.Field)
+			}
+			data = []byte(s)
+		}
+		val := reflect.New(fv.Type())
+		if err := json.Unmarshal(data, val.Interface()); err != nil {
+			return nil, NewError(ErrBadPatch, err.Error(), p.Field)
+		}
+		fv.Set(val.Elem())
+	case "splice":
+		if fv.Kind() != reflect.Slice {
+			return nil, NewError(ErrBadPatch, fmt.Sprintf("field %v is not an array", p.Field), p.Field)
+		}
+		if p.Start < 0 || p.Delete < 0 || p.Start+p.Delete > fv.Len() {
+			return nil, NewError(ErrBadPatch, "splice out of range", p.Field)
+		}
+		items := reflect.New(fv.Type())
+		if len(p.Value) != 0 {
+			if err := json.Unmarshal(p.Value, items.Interface()); err != nil {
+				return nil, NewError(ErrBadPatch, err.Error(), p.Field)
+			}
+		}
+		// Build a new slice, because the old one might be shared
+		result := reflect.MakeSlice(fv.Type(), 0, fv.Len()-p.Delete+items.Elem().Len())
+		result = reflect.AppendSlice(result, fv.Slice(0, p.Start))
+		result = reflect.AppendSlice(result, items.Elem())
+		result = reflect.AppendSlice(result, fv.Slice(p.Start+p.Delete, fv.Len()))
+		fv.Set(result)
+	default:
+		return nil, NewError(ErrBadPatch, fmt.Sprintf("unknown operation %v", p.Op), p.Op)
+	}
+	return m, nil
+}
+
+// fetch returns the value of a model field, e.g. a field tagged `goui:"lazy"`, as requested by the browser.
+// If `binary` is true, byte slices are encoded for transcoding to MessagePack.
+func (s *Window) fetch(inv *invocation, binary bool) ([]byte, error) {
+	if len(inv.Message) != 1 {
+		return nil, NewError(ErrParameterCount, "goui:fetch expects one parameter", nil)
+	}
+	var p patchMessage
+	if err := json.Unmarshal(inv.Message[0], &p); err != nil {
+		return nil, NewError(ErrBadPatch, err.Error(), nil)
+	}
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	_, _, fv, err := s.lookupField(p.ID, p.Field)
+	if err != nil {
+		return nil, err
+	}
+	// Encode the value as plain JSON without model diffs
+	e := newEncodeState()
+	defer encodeStatePool.Put(e)
+	e.binary = binary
+	if err := e.marshal(fv.Interface(), encOpts{escapeHTML: true}); err != nil {
+		return nil, NewError(ErrInternal, err.Error(), nil)
+	}
+	// In binary mode, the value is not JSON. Hence it is not passed to json.Marshal.
+	data := append([]byte(`{"v":`), e.Bytes()...)
+	data = append(data, `,"id":`...)
+	data = strconv.AppendInt(data, int64(inv.ID), 10)
+	return append(data, '}'), nil
+}
+
+// lookupField returns the model with the given ID, a field of the model and the value of the field.
+// The caller must hold the model lock.
+func (s *Window) lookupField(id int, name string) (ModelIface, *Field, reflect.Value, error) {
+	if s.model == nil {
+		return nil, nil, reflect.Value{}, NewError(ErrBadPatch, "the window has no model", nil)
+	}
+	m, v := findModel(s.model, reflect.ValueOf(s.model).Elem(), id)
+	if m == nil {
+		return nil, nil, reflect.Value{}, NewError(ErrBadPatch, fmt.Sprintf("unknown model %v", id), id)
+	}
+	fields := cachedTypeFields(v.Type())
+	i, ok := fields.nameIndex[name]
+	if !ok {
+		return nil, nil, reflect.Value{}, NewError(ErrBadPatch, fmt.Sprintf("unknown field %v", name), name)
+	}
+	f := &fields.list[i]
+	fv := v
+	for _, i := range f.index {
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				return nil, nil, reflect.Value{}, NewError(ErrBadPatch, fmt.Sprintf("field %v is not available", name), name)
+			}
+			fv = fv.Elem()
+		}
+		fv = fv.Field(i)
+	}
+	return m, f, fv, nil
+}
+
+// findModel searches the model with the given ID in the tree of models rooted at `m`, whose struct is `v`.
+// It returns the model and its struct.
+// Models which have not been synced are ignored, because the browser does not know them.
+func findModel(m ModelIface, v reflect.Value, id int) (ModelIface, reflect.Value) {
+	if m.ModelState() == ModelNew {
+		return nil, reflect.Value{}
+	}
+	if m.ModelID() == id {
+		return m, v
+	}
+	for _, c := range childModels(v) {
+		if cm, cv := findModel(c.Interface().(ModelIface), c.Elem(), id); cm != nil {
+			return cm, cv
+		}
+	}
+	return nil, reflect.Value{}
+}
+
+// childModels returns pointers to the child models of the model struct `v`.
+func childModels(v reflect.Value) []reflect.Value {
+	var children []reflect.Value
+	fields := cachedTypeFields(v.Type())
+FieldLoop:
+	for i := range fields.list {
+		f := &fields.list[i]
+		fv := v
+		for _, i := range f.index {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue FieldLoop
+				}
+				fv = fv.Elem()
+			}
+			fv = fv.Field(i)
+		}
+		mv, isModelPtr, isContainer := modelField(f, fv)
+		switch {
+		case isModelPtr:
+			if !mv.IsNil() {
+				children = append(children, mv)
+			}
+		case f.isModel && fv.CanAddr():
+			children = append(children, fv.Addr())
+		case isContainer:
+			children = appendContainerModels(children, mv)
+		case f.isModelMapPtr:
+			iter := fv.MapRange()
+			for iter.Next() {
+				if !iter.Value().IsNil() {
+					children = append(children, iter.Value())
+				}
+			}
+		}
+	}
+	return children
+}
@@ -0,0 +1,298 @@
+// Package replica applies the model diffs of goui to a copy of the model.
+// The diffs are produced by goui.MarshalDiff, goui.Differ and goui.Window.
+// Thus, a Go program can mirror a model of another process, and tests can
+// check the diffs without a browser.
+package replica
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Replica is a copy of a model which is kept up to date by applying model messages.
+// The zero value is an empty replica, i.e. its value is nil.
+type Replica struct {
+	value interface{}
+}
+
+// Apply applies a model message of the form {"m":diff} to the replica.
+// Other keys of the message, e.g. the version sent by a window, are ignored.
+// Like in the browser, {"m":null} sets the model to nil. A window does not send a
+// message if its model has not changed, although MarshalDiff returns {"m":null} then.
+// If an error is returned, the replica might be modified partially.
+func (r *Replica) Apply(msg []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return err
+	}
+	model, ok := m["m"]
+	if !ok {
+		return errors.New("replica: message has no model")
+	}
+	var diff interface{}
+	if err := json.Unmarshal(model, &diff); err != nil {
+		return err
+	}
+	v, err := Apply(r.value, diff)
+	if err != nil {
+		return err
+	}
+	r.value = v
+	return nil
+}
+
+// Value returns the model as produced by json.Unmarshal into an interface{}.
+// Objects which correspond to models have an "_id" key.
+// The result is shared with the replica and must not be modified.
+func (r *Replica) Value() interface{} {
+	return r.value
+}
+
+// Decode decodes the model into `v`, e.g. a pointer to a struct of the same type as the model.
+func (r *Replica) Decode(v interface{}) error {
+	data, err := json.Marshal(r.value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Apply applies `diff` to `old` and returns the new value. It mirrors applyDiff in goui's rpc.js.
+// Both values are composed of the values produced by json.Unmarshal into an interface{}.
+// Objects of `old` are modified in place, even if an error is returned.
+// Apply returns an error where applyDiff throws an exception. It returns an error, too,
+// if the diff is malformed, e.g. if it refers to a model which does not exist.
+func Apply(old interface{}, diff interface{}) (interface{}, error) {
+	d, ok := diff.(map[string]interface{})
+	if !ok {
+		// The value is a primitive or array literal
+		return diff, nil
+	}
+	if a, ok := d["_a"]; ok {
+		// Modify an array
+		arr, ok := old.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("replica: array diff applied to %T", old)
+		}
+		directives, ok := a.([]interface{})
+		if !ok {
+			return nil, errors.New("replica: malformed array diff")
+		}
+		l, err := toInt(d["_l"])
+		if err != nil {
+			return nil, err
+		}
+		var keys []interface{}
+		if k, ok := d["_k"]; ok {
+			if keys, ok = k.([]interface{}); !ok {
+				return nil, errors.New("replica: malformed array diff")
+			}
+		}
+		return applyArrayDiff(arr, directives, l, keys)
+	}
+	if m, ok := d["_m"]; ok {
+		// Modify a map
+		obj, ok := old.(map[string]interface{})
+		if _, n := d["_n"]; n || !ok {
+			// The map has not been synced before, e.g. because it has been nil
+			obj = make(map[string]interface{})
+		}
+		mods, ok := m.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("replica: malformed map diff")
+		}
+		return applyMapDiff(obj, mods, d)
+	}
+	if _, ok := d["_id"]; ok {
+		// The value is an object literal
+		return diff, nil
+	}
+	obj, ok := old.(map[string]interface{})
+	if !ok {
+		// The value is an object which is not a model, e.g. a struct which replaces nil
+		return diff, nil
+	}
+	// Modify an object
+	for key, v := range d {
+		var err error
+		if obj[key], err = Apply(obj[key], v); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// applyArrayDiff interprets the directives of an array diff.
+// The directives are processed from the end to the beginning of the array.
+// `keys` are the IDs of the models which move.
+func applyArrayDiff(arr []interface{}, directives []interface{}, l int, keys []interface{}) ([]interface{}, error) {
+	// Look up the models which move, before the array is modified
+	moved, err := lookupModels(arr, keys)
+	if err != nil {
+		return nil, err
+	}
+	k := len(moved)
+	// Chop the array when necessary
+	if l > len(arr) {
+		return nil, fmt.Errorf("replica: array of length %v chopped to %v", len(arr), l)
+	}
+	arr = append(make([]interface{}, 0, l), arr[:l]...)
+	pos := len(arr)
+	insertCount := 0
+	errRange := errors.New("replica: array diff out of range")
+	for i := len(directives) - 1; i >= 0; i-- {
+		e := directives[i]
+		if _, ok := e.(float64); ok {
+			// Skip elements
+			n, err := toInt(e)
+			if err != nil {
+				return nil, err
+			}
+			if pos -= n; pos < 0 {
+				return nil, errRange
+			}
+			continue
+		}
+		if m, ok := e.(map[string]interface{}); ok {
+			if d, ok := m["_d"]; ok {
+				// Delete elements
+				n, err := toInt(d)
+				if err != nil {
+					return nil, err
+				}
+				if pos -= n; pos < 0 {
+					return nil, errRange
+				}
+				arr = append(arr[:pos], arr[pos+n:]...)
+				continue
+			} else if n, ok := m["_i"]; ok {
+				// The next elements are inserted
+				var err error
+				if insertCount, err = toInt(n); err != nil {
+					return nil, err
+				}
+				continue
+			} else if c, ok := m["_k"]; ok {
+				// Insert models which move
+				n, err := toInt(c)
+				if err != nil {
+					return nil, err
+				}
+				if n > k {
+					return nil, errRange
+				}
+				k -= n
+				arr = insert(arr, pos, moved[k:k+n]...)
+				if v, ok := m["_v"]; ok {
+					if arr[pos], err = Apply(arr[pos], v); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+		}
+		if insertCount > 0 {
+			v, err := Apply(nil, e)
+			if err != nil {
+				return nil, err
+			}
+			arr = insert(arr, pos, v)
+			insertCount--
+		} else {
+			if pos--; pos < 0 {
+				return nil, errRange
+			}
+			var err error
+			if arr[pos], err = Apply(arr[pos], e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return arr, nil
+}
+
+// applyMapDiff removes, copies and sets entries of a map and applies diffs to
+// the models stored in the map.
+func applyMapDiff(obj map[string]interface{}, mods map[string]interface{}, d map[string]interface{}) (map[string]interface{}, error) {
+	old := make(map[string]interface{}, len(obj))
+	for key, v := range obj {
+		old[key] = v
+	}
+	if r, ok := d["_r"].([]interface{}); ok {
+		for _, key := range r {
+			k, ok := key.(string)
+			if !ok {
+				return nil, errors.New("replica: malformed map diff")
+			}
+			delete(obj, k)
+		}
+	}
+	if c, ok := d["_c"].(map[string]interface{}); ok {
+		for key, from := range c {
+			f, ok := from.(string)
+			if !ok {
+				return nil, errors.New("replica: malformed map diff")
+			}
+			obj[key] = old[f]
+		}
+	}
+	if s, ok := d["_s"].(map[string]interface{}); ok {
+		for key, v := range s {
+			obj[key] = v
+		}
+	}
+	for key, v := range mods {
+		var err error
+		if obj[key], err = Apply(obj[key], v); err != nil {
+			return nil, err
+		}
+	}
+	return obj, nil
+}
+
+// lookupModels returns the models of `arr` with the IDs `keys`.
+// If several models have the same ID, the first one is used.
+func lookupModels(arr []interface{}, keys []interface{}) ([]interface{}, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	byID := make(map[float64]interface{}, len(arr))
+	for _, v := range arr {
+		if m, ok := v.(map[string]interface{}); ok {
+			if id, ok := m["_id"].(float64); ok {
+				if _, ok := byID[id]; !ok {
+					byID[id] = m
+				}
+			}
+		}
+	}
+	models := make([]interface{}, len(keys))
+	for i, key := range keys {
+		id, ok := key.(float64)
+		if !ok {
+			return nil, errors.New("replica: malformed array diff")
+		}
+		if models[i], ok = byID[id]; !ok {
+			return nil, fmt.Errorf("replica: model %v does not exist", key)
+		}
+	}
+	return models, nil
+}
+
+func insert(arr []interface{}, pos int, values ...interface{}) []interface{} {
+	result := make([]interface{}, 0, len(arr)+len(values))
+	result = append(result, arr[:pos]...)
+	result = append(result, values...)
+	return append(result, arr[pos:]...)
+}
+
+// toInt converts a non-negative integral number of a diff to an int.
+func toInt(v interface{}) (int, error) {
+	f, ok := v.(float64)
+	if !ok || f < 0 || f != math.Trunc(f) || f > math.MaxInt32 {
+		return 0, fmt.Errorf("replica: %v is not a valid count or index", v)
+	}
+	return int(f), nil
+}
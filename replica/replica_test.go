@@ -0,0 +1,775 @@
+package replica_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/weistn/goui"
+	"github.com/weistn/goui/replica"
+)
+
+type node struct {
+	goui.Model
+	Name string
+	Tags []string
+}
+
+type tree struct {
+	goui.Model
+	Title string
+	List  []*node
+	Vals  []node
+	Grid  [][]*node
+	Slots [3]*node
+	Index map[string]*node
+	Child *node
+	Any   interface{}
+	Meta  *meta
+	Count map[string]int
+}
+
+// meta is a struct which is not a model
+type meta struct {
+	Version int
+	Labels  map[string]string
+}
+
+func TestApply(t *testing.T) {
+	m := &tree{Title: "T", List: []*node{{Name: "a"}, {Name: "b"}}}
+	var r replica.Replica
+	apply := func() {
+		t.Helper()
+		data, err := goui.MarshalDiff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Apply(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apply()
+	m.List[1].Name = "B"
+	m.List[1].ModelDirty()
+	m.List = append(m.List, &node{Name: "c"})
+	m.ModelDirty()
+	apply()
+
+	var c tree
+	if err := r.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Title != "T" || len(c.List) != 3 || c.List[1].Name != "B" || c.List[2].Name != "c" {
+		t.Fatalf("Unexpected model %v", r.Value())
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	for _, diff := range []string{
+		`{"_a":[0,1],"_l":5}`,
+		`{"_a":[0,3],"_l":2}`,
+		`{"_a":[0,{"_d":3}],"_l":2}`,
+		`{"_a":[0,1.5],"_l":2}`,
+		`{"_a":[0],"_l":-1}`,
+		`{"_a":7,"_l":1}`,
+		`{"_m":7}`,
+	} {
+		var d interface{}
+		if err := json.Unmarshal([]byte(diff), &d); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := replica.Apply([]interface{}{"x", "y"}, d); err == nil {
+			t.Fatalf("Expected an error for %v", diff)
+		}
+		if _, err := replica.Apply("x", d); err == nil {
+			t.Fatalf("Expected an error for %v applied to a string", diff)
+		}
+	}
+	// Like rpc.js, an array diff cannot be applied to null
+	if _, err := replica.Apply(nil, map[string]interface{}{"_a": []interface{}{0.0}, "_l": 0.0}); err == nil {
+		t.Fatal("Expected an error for an array diff applied to nil")
+	}
+}
+
+// TestRoundTrip modifies a model randomly and checks that applying the diffs
+// yields the same result as serializing the entire model.
+func TestRoundTrip(t *testing.T) {
+	var seqs []sequence
+	for seed := int64(0); seed < 200; seed++ {
+		seqs = append(seqs, roundTrip(t, seed)...)
+	}
+	checkJS(t, seqs)
+}
+
+// roundTrip returns the messages sent by MarshalDiff and by a Differ.
+func roundTrip(t *testing.T, seed int64) []sequence {
+	rnd := rand.New(rand.NewSource(seed))
+	count := 0
+	newNode := func() *node {
+		count++
+		return &node{Name: "n" + strconv.Itoa(count)}
+	}
+	m := &tree{Index: map[string]*node{}}
+	var r replica.Replica
+	d := goui.NewDiffer()
+	var rd replica.Replica
+	var seq, seq2 sequence
+
+	for step := 0; step < 40; step++ {
+		op := mutate(rnd, m, newNode)
+
+		data, err := goui.MarshalDiff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := apply(&r, data); err != nil {
+			t.Fatalf("seed %v, step %v, %v: %v: %s", seed, step, op, err, data)
+		}
+		// A Differ produces the same result independently
+		data2, err := d.Diff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := apply(&rd, data2); err != nil {
+			t.Fatalf("seed %v, step %v, %v: differ: %v: %s", seed, step, op, err, data2)
+		}
+
+		// The entire model as sent by a new differ
+		full, err := goui.NewDiffer().Diff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected replica.Replica
+		if err := expected.Apply(full); err != nil {
+			t.Fatal(err)
+		}
+		want := withoutIDs(expected.Value())
+		if got := withoutIDs(r.Value()); !reflect.DeepEqual(got, want) {
+			t.Fatalf("seed %v, step %v, %v: diff %s\ngot  %v\nwant %v", seed, step, op, data, got, want)
+		}
+		if got := withoutIDs(rd.Value()); !reflect.DeepEqual(got, want) {
+			t.Fatalf("seed %v, step %v, %v: differ diff %s\ngot  %v\nwant %v", seed, step, op, data2, got, want)
+		}
+		checkIDs(t, reflect.ValueOf(m), r.Value(), func(m goui.ModelIface) (int, bool) { return m.ModelID(), true })
+		checkIDs(t, reflect.ValueOf(m), rd.Value(), d.ModelID)
+		seq.add(t, data, &r)
+		seq2.add(t, data2, &rd)
+	}
+	return []sequence{seq, seq2}
+}
+
+// mutate applies a random modification to the model and returns its description.
+// Models are moved between containers, but each model is stored once at most.
+func mutate(rnd *rand.Rand, m *tree, newNode func() *node) string {
+	// take removes a random model from a list, or returns a new one
+	take := func(list *[]*node) *node {
+		if len(*list) == 0 || rnd.Intn(3) == 0 {
+			return newNode()
+		}
+		i := rnd.Intn(len(*list))
+		n := (*list)[i]
+		*list = append((*list)[:i:i], (*list)[i+1:]...)
+		return n
+	}
+	insert := func(list []*node, n *node) []*node {
+		i := rnd.Intn(len(list) + 1)
+		return append(list[:i:i], append([]*node{n}, list[i:]...)...)
+	}
+	rename := func(n *node) {
+		if n != nil {
+			n.Name += "'"
+			if rnd.Intn(2) == 0 {
+				n.Tags = append(n.Tags, "t")
+			}
+			n.ModelDirty()
+		}
+	}
+	defer m.ModelDirty()
+
+	switch op := rnd.Intn(17); op {
+	case 0:
+		m.List = insert(m.List, newNode())
+		return "insert"
+	case 1:
+		if len(m.List) > 0 {
+			take(&m.List)
+		}
+		return "delete"
+	case 2:
+		// Move an element within the list
+		if len(m.List) > 0 {
+			n := m.List[rnd.Intn(len(m.List))]
+			for i, x := range m.List {
+				if x == n {
+					m.List = append(m.List[:i:i], m.List[i+1:]...)
+					break
+				}
+			}
+			m.List = insert(m.List, n)
+		}
+		return "move"
+	case 3:
+		if len(m.List) > 0 {
+			rename(m.List[rnd.Intn(len(m.List))])
+		}
+		return "rename"
+	case 4:
+		rnd.Shuffle(len(m.List), func(i, j int) { m.List[i], m.List[j] = m.List[j], m.List[i] })
+		if len(m.List) > 0 && rnd.Intn(2) == 0 {
+			rename(m.List[0])
+		}
+		return "shuffle"
+	case 5:
+		// Model structs are copied
+		switch {
+		case len(m.Vals) > 1 && rnd.Intn(2) == 0:
+			i, j := rnd.Intn(len(m.Vals)), rnd.Intn(len(m.Vals))
+			m.Vals[i], m.Vals[j] = m.Vals[j], m.Vals[i]
+		case len(m.Vals) > 0 && rnd.Intn(2) == 0:
+			i := rnd.Intn(len(m.Vals))
+			m.Vals = append(m.Vals[:i], m.Vals[i+1:]...)
+		default:
+			m.Vals = append(m.Vals, node{Name: newNode().Name})
+		}
+		return "values"
+	case 6:
+		if len(m.Vals) > 0 {
+			i := rnd.Intn(len(m.Vals))
+			m.Vals[i].Name += "'"
+			m.Vals[i].ModelDirty()
+		}
+		return "rename value"
+	case 7:
+		// Add a row, or move a model into a row
+		if len(m.Grid) == 0 || rnd.Intn(3) == 0 {
+			m.Grid = append(m.Grid, []*node{take(&m.List)})
+		} else {
+			i := rnd.Intn(len(m.Grid))
+			m.Grid[i] = insert(m.Grid[i], take(&m.List))
+		}
+		return "grid insert"
+	case 8:
+		// Remove a row, or move a model out of a row
+		if len(m.Grid) > 0 {
+			i := rnd.Intn(len(m.Grid))
+			if rnd.Intn(3) == 0 {
+				m.List = append(m.List, m.Grid[i]...)
+				m.Grid = append(m.Grid[:i:i], m.Grid[i+1:]...)
+			} else if len(m.Grid[i]) > 0 {
+				m.List = insert(m.List, take(&m.Grid[i]))
+			}
+		}
+		return "grid remove"
+	case 9:
+		if len(m.Grid) > 1 {
+			i, j := rnd.Intn(len(m.Grid)), rnd.Intn(len(m.Grid))
+			m.Grid[i], m.Grid[j] = m.Grid[j], m.Grid[i]
+		}
+		return "grid swap"
+	case 10:
+		if len(m.Grid) > 0 {
+			row := m.Grid[rnd.Intn(len(m.Grid))]
+			if len(row) > 0 {
+				rename(row[rnd.Intn(len(row))])
+			}
+		}
+		return "grid rename"
+	case 11:
+		// Swap a slot with the list
+		i := rnd.Intn(len(m.Slots))
+		old := m.Slots[i]
+		if rnd.Intn(3) == 0 {
+			m.Slots[i] = nil
+		} else {
+			m.Slots[i] = take(&m.List)
+		}
+		if old != nil {
+			m.List = insert(m.List, old)
+		}
+		return "slot"
+	case 12:
+		// Put a model into the map, or remove one
+		key := strconv.Itoa(rnd.Intn(4))
+		if old, ok := m.Index[key]; ok && rnd.Intn(2) == 0 {
+			delete(m.Index, key)
+			m.List = insert(m.List, old)
+		} else {
+			if ok {
+				m.List = insert(m.List, old)
+			}
+			m.Index[key] = take(&m.List)
+		}
+		return "map"
+	case 13:
+		for _, n := range m.Index {
+			rename(n)
+			break
+		}
+		rename(m.Slots[rnd.Intn(len(m.Slots))])
+		rename(m.Child)
+		return "rename map, slot and child"
+	case 14:
+		// Swap the child with the list
+		old := m.Child
+		if rnd.Intn(3) == 0 {
+			m.Child = nil
+		} else {
+			m.Child = take(&m.List)
+		}
+		if old != nil {
+			m.List = insert(m.List, old)
+		}
+		return "child"
+	case 15:
+		// Plain structs and maps go from nil to non-nil and back
+		key := strconv.Itoa(rnd.Intn(3))
+		switch {
+		case rnd.Intn(4) == 0:
+			m.Meta, m.Count = nil, nil
+		case m.Meta == nil:
+			m.Meta = &meta{Labels: map[string]string{key: "l"}}
+			m.Count = map[string]int{key: 1}
+		case rnd.Intn(3) == 0:
+			delete(m.Meta.Labels, key)
+			delete(m.Count, key)
+		default:
+			m.Meta.Version++
+			m.Meta.Labels[key] += "l"
+			m.Count[key]++
+		}
+		return "plain"
+	default:
+		// The interface holds models, plain values or nothing
+		var old []*node
+		switch a := m.Any.(type) {
+		case []*node:
+			old = a
+		case *node:
+			old = []*node{a}
+		}
+		switch rnd.Intn(4) {
+		case 0:
+			m.Any = []*node{take(&m.List), take(&m.List)}
+		case 1:
+			m.Any = take(&m.List)
+		case 2:
+			m.Any = "plain"
+		default:
+			m.Any = nil
+		}
+		m.List = append(m.List, old...)
+		m.Title = fmt.Sprintf("title %v", rnd.Intn(10))
+		return "interface"
+	}
+}
+
+// withoutIDs returns a copy of v without the "_id" keys.
+func withoutIDs(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(x))
+		for key, val := range x {
+			if key != "_id" {
+				m[key] = withoutIDs(val)
+			}
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(x))
+		for i, val := range x {
+			arr[i] = withoutIDs(val)
+		}
+		return arr
+	}
+	return v
+}
+
+// checkIDs checks that each model of `v` is represented by an object with the ID of the model in `r`.
+func checkIDs(t *testing.T, v reflect.Value, r interface{}, id func(goui.ModelIface) (int, bool)) {
+	t.Helper()
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if m, ok := v.Interface().(goui.ModelIface); ok {
+			obj, _ := r.(map[string]interface{})
+			want, ok := id(m)
+			if got, _ := obj["_id"].(float64); !ok || int(got) != want {
+				t.Fatalf("Expected ID %v, got %v", want, obj["_id"])
+			}
+		}
+		checkIDs(t, v.Elem(), r, id)
+	case reflect.Interface:
+		checkIDs(t, v.Elem(), r, id)
+	case reflect.Struct:
+		obj, _ := r.(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Type().Field(i); f.PkgPath == "" && !f.Anonymous {
+				checkIDs(t, v.Field(i), obj[f.Name], id)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		arr, _ := r.([]interface{})
+		for i := 0; i < v.Len() && i < len(arr); i++ {
+			checkIDs(t, v.Index(i), arr[i], id)
+		}
+	case reflect.Map:
+		obj, _ := r.(map[string]interface{})
+		iter := v.MapRange()
+		for iter.Next() {
+			checkIDs(t, iter.Value(), obj[iter.Key().String()], id)
+		}
+	}
+}
+
+type list struct {
+	goui.Model
+	Items []*node
+}
+
+// FuzzSliceDiff inserts, deletes, moves and modifies the models of a slice
+// as instructed by the fuzzer and checks that applying the diff to the previous
+// state yields the new state.
+// Each pair of bytes is an operation, a zero operation syncs the model.
+func FuzzSliceDiff(f *testing.F) {
+	f.Add([]byte{1, 0, 1, 1, 1, 2, 0, 0, 3, 0x20, 0, 0})
+	f.Add([]byte{1, 0, 1, 0, 1, 0, 1, 0, 0, 0, 5, 0, 3, 2, 4, 1, 0, 0})
+	f.Add([]byte{1, 0, 1, 0, 1, 0, 1, 0, 0, 0, 7, 0, 0, 0, 6, 0, 0, 0, 3, 0x31, 3, 0x02})
+	f.Add([]byte{1, 0, 1, 0, 1, 0, 0, 0, 8, 0, 8, 2, 0, 0, 3, 0x30, 4, 1, 0, 0, 2, 0, 8, 1})
+	f.Add([]byte{1, 0, 1, 0, 1, 0, 1, 0, 0, 0, 2, 1, 2, 1, 0, 0, 1, 0x80, 0, 0, 1, 0x83, 0, 0})
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		ops := make([]byte, 2+rnd.Intn(60))
+		rnd.Read(ops)
+		f.Add(ops)
+	}
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		if len(ops) > 1000 {
+			// Long inputs are slow and do not find more bugs
+			ops = ops[:1000]
+		}
+		count := 0
+		var removed []*node
+		m := &list{}
+		var r replica.Replica
+		d := goui.NewDiffer()
+		var rd replica.Replica
+		for len(ops) >= 2 {
+			op, arg := ops[0], int(ops[1])
+			ops = ops[2:]
+			n := len(m.Items)
+			switch op % 9 {
+			case 0:
+				checkSync(t, m, &r, d, &rd)
+				continue
+			case 1:
+				// Insert a new model or a model which has been deleted before
+				count++
+				item := &node{Name: "n" + strconv.Itoa(count)}
+				if arg >= 128 && len(removed) > 0 {
+					item, removed = removed[len(removed)-1], removed[:len(removed)-1]
+				}
+				i := arg % (n + 1)
+				m.Items = append(m.Items[:i:i], append([]*node{item}, m.Items[i:]...)...)
+			case 2:
+				// Delete a model
+				if n == 0 {
+					continue
+				}
+				i := arg % n
+				if m.Items[i] != nil {
+					removed = append(removed, m.Items[i])
+				}
+				m.Items = append(m.Items[:i:i], m.Items[i+1:]...)
+			case 3:
+				// Move a model from one position to another
+				if n == 0 {
+					continue
+				}
+				from, to := (arg&0xf)%n, (arg>>4)%n
+				item := m.Items[from]
+				items := append(m.Items[:from:from], m.Items[from+1:]...)
+				m.Items = append(items[:to:to], append([]*node{item}, items[to:]...)...)
+			case 4:
+				// Modify a model
+				if n == 0 || m.Items[arg%n] == nil {
+					continue
+				}
+				item := m.Items[arg%n]
+				item.Name += "'"
+				item.ModelDirty()
+			case 5:
+				// Swap two models
+				if n == 0 {
+					continue
+				}
+				i, j := (arg&0xf)%n, (arg>>4)%n
+				m.Items[i], m.Items[j] = m.Items[j], m.Items[i]
+			case 6:
+				// Reverse the slice
+				for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+					m.Items[i], m.Items[j] = m.Items[j], m.Items[i]
+				}
+			case 7:
+				// Store nil
+				if n == 0 {
+					continue
+				}
+				m.Items[arg%n] = nil
+			case 8:
+				// Store a model a second time
+				if n == 0 {
+					continue
+				}
+				m.Items = append(m.Items, m.Items[arg%n])
+			}
+			m.ModelDirty()
+		}
+		checkSync(t, m, &r, d, &rd)
+	})
+}
+
+// checkSync syncs the model `m` via MarshalDiff to `r` and via `d` to `rd` and compares
+// both replicas with the entire model.
+func checkSync(t *testing.T, m goui.ModelIface, r *replica.Replica, d *goui.Differ, rd *replica.Replica) {
+	data, err := goui.MarshalDiff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(r, data); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+	data2, err := d.Diff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := apply(rd, data2); err != nil {
+		t.Fatalf("differ: %v: %s", err, data2)
+	}
+	full, err := goui.NewDiffer().Diff(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected replica.Replica
+	if err := expected.Apply(full); err != nil {
+		t.Fatal(err)
+	}
+	want := withoutIDs(expected.Value())
+	if got := withoutIDs(r.Value()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("diff %s\ngot  %v\nwant %v", data, got, want)
+	}
+	if got := withoutIDs(rd.Value()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("differ diff %s\ngot  %v\nwant %v", data2, got, want)
+	}
+}
+
+// apply applies a message of MarshalDiff or a Differ to the replica. Like a window,
+// it skips {"m":null}, which means that the model has not changed.
+func apply(r *replica.Replica, msg []byte) error {
+	if string(msg) == `{"m":null}` {
+		return nil
+	}
+	return r.Apply(msg)
+}
+
+// sequence is a list of model messages and the value of a replica after each message.
+type sequence struct {
+	msgs   []string
+	values []string
+}
+
+func (s *sequence) add(t *testing.T, msg []byte, r *replica.Replica) {
+	t.Helper()
+	if string(msg) == `{"m":null}` {
+		return
+	}
+	value, err := json.Marshal(r.Value())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.msgs = append(s.msgs, string(msg))
+	s.values = append(s.values, string(value))
+}
+
+// jsDriver applies each list of messages read from stdin to an empty API object
+// and prints the model after each message.
+const jsDriver = `
+const seqs = JSON.parse(require("fs").readFileSync(0, "utf8"))
+const result = seqs.map(msgs => {
+    const api = {}
+    return msgs.map(msg => {
+        applyDiff(api, "data", undefined, false, JSON.parse(msg).m)
+        return JSON.stringify(api.data === undefined ? null : api.data)
+    })
+})
+process.stdout.write(JSON.stringify(result))
+`
+
+// checkJS applies the messages with applyDiff of goui's rpc.js and checks that the
+// browser sees the same values as the replica. It is skipped if node is not installed.
+func checkJS(t *testing.T, seqs []sequence) {
+	t.Helper()
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+	rpcjs, err := os.ReadFile("../js/rpc.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := strings.Index(string(rpcjs), "    function applyDiff(")
+	end := strings.Index(string(rpcjs[start:]), "\n    }\n")
+	if start < 0 || end < 0 {
+		t.Fatal("applyDiff not found in rpc.js")
+	}
+	var msgs [][]string
+	for _, seq := range seqs {
+		msgs = append(msgs, seq.msgs)
+	}
+	input, err := json.Marshal(msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(node, "-e", string(rpcjs[start:start+end+6])+jsDriver)
+	cmd.Stdin = strings.NewReader(string(input))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v: %v", err, stderr.String())
+	}
+	var values [][]string
+	if err := json.Unmarshal(out, &values); err != nil {
+		t.Fatal(err)
+	}
+	for i, seq := range seqs {
+		for j, value := range values[i] {
+			var got, want interface{}
+			if err := json.Unmarshal([]byte(value), &got); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(seq.values[j]), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("message %v of sequence %v: %v\nrpc.js  %v\nreplica %v", j, i, seq.msgs[j], value, seq.values[j])
+			}
+		}
+	}
+}
+
+func TestNilToMapJS(t *testing.T) {
+	m := &tree{}
+	var r replica.Replica
+	var seq sequence
+	sync := func() {
+		t.Helper()
+		data, err := goui.MarshalDiff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Apply(data); err != nil {
+			t.Fatal(err)
+		}
+		seq.add(t, data, &r)
+	}
+	sync()
+	// Maps and plain structs go from nil to non-nil
+	m.Index = map[string]*node{"a": {Name: "A"}}
+	m.Count = map[string]int{"x": 1}
+	m.Meta = &meta{Version: 1}
+	m.ModelDirty()
+	sync()
+	m.Index["b"] = &node{Name: "B"}
+	m.Count = nil
+	m.Meta.Version = 2
+	m.ModelDirty()
+	sync()
+	m.Count = map[string]int{"y": 2}
+	m.Meta = nil
+	m.ModelDirty()
+	sync()
+	want := `{"Any":null,"Child":null,"Count":{"y":2},"Grid":null,"Index":{"a":{"Name":"A","Tags":null},"b":{"Name":"B","Tags":null}},"List":null,"Meta":null,"Slots":[null,null,null],"Title":"","Vals":null}`
+	if got, _ := json.Marshal(withoutIDs(r.Value())); string(got) != want {
+		t.Fatalf("Expected %v, got %s", want, got)
+	}
+	checkJS(t, []sequence{seq})
+}
+
+// foreign implements goui.ModelIface without embedding goui.Model
+type foreign struct {
+	model goui.Model
+	Count map[string]int
+}
+
+func (f *foreign) ModelDirty()                 { f.model.ModelDirty() }
+func (f *foreign) ModelChildDirty()            { f.model.ModelChildDirty() }
+func (f *foreign) ModelSynced()                { f.model.ModelSynced() }
+func (f *foreign) ModelState() goui.ModelState { return f.model.ModelState() }
+func (f *foreign) ModelSwapIndex(i int) int    { return f.model.ModelSwapIndex(i) }
+func (f *foreign) ModelTestSync(parent goui.ModelIface, field *goui.Field) goui.ModelState {
+	return f.model.ModelTestSync(parent, field)
+}
+func (f *foreign) ModelID() int { return f.model.ModelID() }
+
+func TestForeignModelJS(t *testing.T) {
+	m := &foreign{Count: map[string]int{"x": 1, "y": 2}}
+	var r replica.Replica
+	var seq sequence
+	for i := 0; i < 2; i++ {
+		data, err := goui.MarshalDiff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Apply(data); err != nil {
+			t.Fatal(err)
+		}
+		seq.add(t, data, &r)
+		delete(m.Count, "y")
+		m.ModelDirty()
+	}
+	want := `{"Count":{"x":1}}`
+	if got, _ := json.Marshal(withoutIDs(r.Value())); string(got) != want {
+		t.Fatalf("Expected %v, got %s", want, got)
+	}
+	checkJS(t, []sequence{seq})
+}
+
+func TestMapKeysJS(t *testing.T) {
+	m := &tree{Index: map[string]*node{"a\x01": {Name: "A"}, "\xff": {Name: "B"}}}
+	var r replica.Replica
+	var seq sequence
+	sync := func() {
+		t.Helper()
+		data, err := goui.MarshalDiff(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(data) {
+			t.Fatalf("Invalid JSON %s", data)
+		}
+		if err := r.Apply(data); err != nil {
+			t.Fatal(err)
+		}
+		seq.add(t, data, &r)
+	}
+	sync()
+	// Move the models from keys which must be escaped to other keys
+	m.Index["b"], m.Index["c"] = m.Index["a\x01"], m.Index["\xff"]
+	delete(m.Index, "a\x01")
+	delete(m.Index, "\xff")
+	m.ModelDirty()
+	sync()
+	want := `{"b":{"Name":"A","Tags":null},"c":{"Name":"B","Tags":null}}`
+	if got, _ := json.Marshal(withoutIDs(r.Value().(map[string]interface{})["Index"])); string(got) != want {
+		t.Fatalf("Expected %v, got %s", want, got)
+	}
+	checkJS(t, []sequence{seq})
+}
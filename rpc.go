@@ -2,8 +2,8 @@ package goui
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"reflect"
 	"strconv"
@@ -20,6 +20,22 @@ type invocation struct {
 	ID      int               `json:"id"`
 }
 
+// decodeInvocation decodes a message of the client.
+// If the message is malformed, but carries an ID, `reply` is the error to send
+// for this ID, such that the caller does not wait forever.
+func decodeInvocation(msg []byte) (inv invocation, reply []byte, err error) {
+	if err = json.Unmarshal(msg, &inv); err == nil {
+		return inv, nil, nil
+	}
+	var head struct {
+		ID *int `json:"id"`
+	}
+	if json.Unmarshal(msg, &head) == nil && head.ID != nil {
+		reply = errorResult(*head.ID, NewError(ErrBadParameter, "malformed request: "+err.Error(), nil))
+	}
+	return inv, reply, err
+}
+
 // resultMessage is the message sent from the server to the client in response
 // to an invocation
 type resultMessage struct {
@@ -32,6 +48,9 @@ type resultMessage struct {
 // Dispatcher can invoke functions on an object.
 // A function call is passed in as a JSON message, the
 // function is called and the result is returned as a JSON message.
+// If the first parameter of a function is a context.Context, the
+// context of the call is passed to the function.
+// Functions may be called concurrently, see Concurrent.
 type Dispatcher struct {
 	funcs map[string]reflect.Method
 	obj   interface{}
@@ -41,7 +60,20 @@ type Dispatcher struct {
 var rpcjs string
 
 // GenerateJSCode creates a client-side javascript proxy for the object.
+// The script assigns the API to `window.go`.
 func GenerateJSCode(obj interface{}) string {
+	return generateJS(obj, false)
+}
+
+// GenerateJSModule creates a client-side javascript proxy for the object as an ES module.
+// The module exports the API as `go` and as default export. It does not assign `window.go`.
+// The module can be bundled with the application code, because it does not depend on the
+// URL it is loaded from. However, a page must load either the script or the module, not both.
+func GenerateJSModule(obj interface{}) string {
+	return generateJS(obj, true)
+}
+
+func generateJS(obj interface{}, module bool) string {
 	// Generate JS stubs for all exported Go functions
 	var apis []string
 	t := reflect.TypeOf(obj)
@@ -49,18 +81,19 @@ func GenerateJSCode(obj interface{}) string {
 		m := t.Method(i)
 		var api = strconv.Quote(m.Name) + ": async function("
 		var params []string
-		for i := 1; i < m.Type.NumIn(); i++ {
+		for i := firstParam(m); i < m.Type.NumIn(); i++ {
 			//            it := m.Type.In(i)
-			paramname := fmt.Sprintf("p%v", i)
+			paramname := fmt.Sprintf("p%v", len(params)+1)
 			params = append(params, paramname)
 		}
-		api += strings.Join(params, ",")
+		// The optional last parameter is an AbortSignal that cancels the call
+		api += strings.Join(append(params, "signal"), ",")
 		api += ") {\n"
 		api += "return new Promise((ff, rej) => {"
 		api += "  try {\n"
-		api += fmt.Sprintf("    send({\"n\": %v, \"v\": [%v]}, ff, rej);\n", strconv.Quote(m.Name), strings.Join(params, ","))
+		api += fmt.Sprintf("    send({\"n\": %v, \"v\": [%v]}, ff, rej, signal);\n", strconv.Quote(m.Name), strings.Join(params, ","))
 		api += "  } catch(e) {\n"
-		api += "    rej('RPC error');\n"
+		api += "    rej(e);\n"
 		api += "  }"
 		api += "})}\n"
 		apis = append(apis, api)
@@ -69,7 +102,11 @@ func GenerateJSCode(obj interface{}) string {
 
 	tmpl := template.Must(template.New("_rpc.js").Parse(rpcjs))
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, api); err != nil {
+	data := struct {
+		API    string
+		Module bool
+	}{api, module}
+	if err := tmpl.Execute(&buf, data); err != nil {
 		panic(err)
 	}
 	return buf.String()
@@ -92,96 +129,73 @@ func NewDispatcher(obj interface{}) *Dispatcher {
 }
 
 var errorInterface = reflect.TypeOf((*error)(nil)).Elem()
+var contextInterface = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// firstParam returns the index of the first parameter of the method
+// that is passed in from JavaScript.
+// The receiver and an optional context.Context are not passed in from JavaScript.
+func firstParam(m reflect.Method) int {
+	if m.Type.NumIn() > 1 && m.Type.In(1) == contextInterface {
+		return 2
+	}
+	return 1
+}
+
+// Concurrent returns true if the function `name` accepts a context.Context as its first parameter.
+// Windows run such functions concurrently with other calls. They must modify models inside
+// Window.Update only.
+// All other functions are called one at a time while holding the model locks of all windows,
+// which excludes Window.Update and syncing. Hence they can modify the models directly, but
+// must not call Window.Update or Window.SyncModel.
+func (d *Dispatcher) Concurrent(name string) bool {
+	f, ok := d.funcs[name]
+	return ok && firstParam(f) == 2
+}
 
 // Dispatch decodes the JSON msg and invokes a function on the object.
 // It returns a JSON encoded return message.
 func (d *Dispatcher) Dispatch(inv *invocation) ([]byte, error) {
-	println("Invoke", inv.Name)
+	return d.DispatchContext(context.Background(), inv)
+}
 
+// DispatchContext is like Dispatch. If the function accepts a context.Context
+// as its first parameter, `ctx` is passed to the function.
+func (d *Dispatcher) DispatchContext(ctx context.Context, inv *invocation) ([]byte, error) {
 	f, ok := d.funcs[inv.Name]
 	if !ok {
-		return nil, errors.New("unknown method")
+		return nil, NewError(ErrUnknownMethod, fmt.Sprintf("unknown method %v", inv.Name), nil)
 	}
 	vals := make([]reflect.Value, f.Type.NumIn())
 	vals[0] = reflect.ValueOf(d.obj)
-	if f.Type.NumIn() != len(inv.Message)+1 {
-		return nil, errors.New("wrong parameter count")
+	first := firstParam(f)
+	if first == 2 {
+		vals[1] = reflect.ValueOf(ctx)
+	}
+	if f.Type.NumIn() != len(inv.Message)+first {
+		return nil, NewError(ErrParameterCount, fmt.Sprintf("%v expects %v parameters", inv.Name, f.Type.NumIn()-first), nil)
 	}
-	for i := 1; i < f.Type.NumIn(); i++ {
+	for i := first; i < f.Type.NumIn(); i++ {
 		t := f.Type.In(i)
 		switch t.Kind() {
-		case reflect.Int:
-			var v int
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Int8:
-			var v int8
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Int16:
-			var v int16
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Int32:
-			var v int32
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Int64:
-			var v int64
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Uint:
-			var v uint
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Uint8:
-			var v uint8
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Uint16:
-			var v uint16
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Uint32:
-			var v uint32
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Uint64:
-			var v uint64
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Float32:
-			var v float32
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Float64:
-			var v float64
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Bool:
-			var v bool
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.String:
-			var v string
-			json.Unmarshal(inv.Message[i-1], &v)
-			vals[i] = reflect.ValueOf(v)
-		case reflect.Map, reflect.Struct, reflect.Slice, reflect.Array:
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64, reflect.Bool, reflect.String,
+			reflect.Map, reflect.Struct, reflect.Slice, reflect.Array:
 			v := reflect.New(t)
-			err := json.Unmarshal(inv.Message[i-1], v.Interface())
+			err := json.Unmarshal(inv.Message[i-first], v.Interface())
 			if err != nil {
-				return nil, err
+				return nil, badParameter(i-first, err)
 			}
 			vals[i] = v.Elem()
 		case reflect.Ptr:
 			v := reflect.New(t.Elem())
-			err := json.Unmarshal(inv.Message[i-1], v.Interface())
+			err := json.Unmarshal(inv.Message[i-first], v.Interface())
 			if err != nil {
-				return nil, err
+				return nil, badParameter(i-first, err)
 			}
 			vals[i] = v
 		default:
-			return nil, errors.New("type not supported")
+			return nil, NewError(ErrBadParameter, fmt.Sprintf("type of parameter %v not supported", i-first+1), nil)
 		}
 	}
 	rets := f.Func.Call(vals)
@@ -202,7 +216,7 @@ func (d *Dispatcher) Dispatch(inv *invocation) ([]byte, error) {
 	if returnsErr {
 		err := (rets[len(rets)-1].Interface())
 		if err != nil {
-			result.Error = err.(error).Error()
+			result.Error = newErrorMessage(err.(error))
 		}
 	}
 	// In case of an error, do not marshal the other parameters
@@ -226,7 +240,26 @@ func (d *Dispatcher) Dispatch(inv *invocation) ([]byte, error) {
 	}
 	data, err := json.Marshal(result)
 	if err != nil {
-		return nil, err
+		return nil, NewError(ErrInternal, err.Error(), nil)
 	}
 	return data, nil
 }
+
+func badParameter(index int, err error) error {
+	return NewError(ErrBadParameter, fmt.Sprintf("parameter %v: %v", index+1, err.Error()), nil)
+}
+
+// errorResult returns the result message that reports a failed invocation.
+func errorResult(id int, err error) []byte {
+	result := &resultMessage{
+		ID:    id,
+		Error: newErrorMessage(err),
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		// The error data cannot be marshalled
+		result.Error = &errorMessage{Code: ErrInternal, Message: err.Error()}
+		data, _ = json.Marshal(result)
+	}
+	return data
+}
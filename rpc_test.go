@@ -0,0 +1,38 @@
+package goui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateJS(t *testing.T) {
+	script := GenerateJSCode(&tsRemote{})
+	if !strings.HasPrefix(script, "window.go = ") || strings.Contains(script, "export") {
+		t.Fatal("The script must assign window.go")
+	}
+	module := GenerateJSModule(&tsRemote{})
+	if strings.Contains(module, "window.go") || !strings.Contains(module, "export { go };") {
+		t.Fatal("The module must export go")
+	}
+	for _, code := range []string{script, module} {
+		if !strings.Contains(code, `"Split": async function(p1,signal)`) {
+			t.Fatal("Missing stub for Split")
+		}
+	}
+}
+
+func TestDecodeInvocation(t *testing.T) {
+	inv, reply, err := decodeInvocation([]byte(`{"id":3,"n":"Double","v":[21]}`))
+	if err != nil || reply != nil || inv.ID != 3 || inv.Name != "Double" || len(inv.Message) != 1 {
+		t.Fatalf("Unexpected result %v %s %v", inv, reply, err)
+	}
+	// The caller of a malformed request receives an error
+	_, reply, err = decodeInvocation([]byte(`{"id":3,"m":"Foo","v":5}`))
+	if err == nil || !strings.HasPrefix(string(reply), `{"e":{"code":"goui:bad_parameter"`) || !strings.HasSuffix(string(reply), `"id":3}`) {
+		t.Fatalf("Unexpected result %s %v", reply, err)
+	}
+	// Without an ID, nobody waits for a reply
+	if _, reply, err = decodeInvocation([]byte(`{"v":5}`)); err == nil || reply != nil {
+		t.Fatalf("Unexpected result %s %v", reply, err)
+	}
+}
@@ -0,0 +1,90 @@
+package goui
+
+import (
+	"time"
+)
+
+// syncNotifier is the parent of a window's root model.
+// ModelDirty propagates to the root model and from there to the notifier,
+// which schedules a sync of the model.
+type syncNotifier struct {
+	Model
+	window *Window
+}
+
+// ModelChildDirty is called when the root model becomes dirty.
+func (n *syncNotifier) ModelChildDirty() {
+	n.window.scheduleSync()
+}
+
+// SetSyncInterval enables syncing the model in the background.
+// Each time the model becomes dirty, the window schedules a sync.
+// Multiple modifications are coalesced, and the window syncs at most once per `interval`,
+// e.g. every 16ms or 100ms. Thus, background workers can report progress at a high rate
+// without flooding the websocket.
+// Modifications made by Update are synced the same way, i.e. Update does not sync immediately.
+// If change detection is enabled, the window checks for changes once per interval.
+// The model is still synced immediately after each call of a remote method.
+// An interval of zero disables background syncing, which is the default.
+//
+// Modifications of the model, including calls to ModelDirty, must happen inside Update
+// if they are not made by a remote method without a context.Context.
+func (s *Window) SetSyncInterval(interval time.Duration) {
+	s.lock.Lock()
+	s.syncInterval = interval
+	start := interval > 0 && !s.syncLoopRunning
+	if start {
+		s.syncLoopRunning = true
+	}
+	s.lock.Unlock()
+	if start {
+		go s.syncLoop()
+	}
+}
+
+// scheduleSync asks the sync loop to sync the model.
+// It does not block and must not acquire locks, because it is called while
+// the model is modified.
+func (s *Window) scheduleSync() {
+	select {
+	case s.syncTrigger <- struct{}{}:
+	default:
+		// A sync is already scheduled
+	}
+}
+
+// syncLoop syncs the model when a sync has been scheduled, but not more often than once per interval.
+func (s *Window) syncLoop() {
+	for {
+		s.lock.Lock()
+		interval := s.syncInterval
+		var tick <-chan time.Time
+		if s.detectChanges {
+			// Modifications are noticed only by looking for them
+			tick = time.After(interval)
+		}
+		if interval <= 0 {
+			s.syncLoopRunning = false
+			s.lock.Unlock()
+			return
+		}
+		s.lock.Unlock()
+
+		select {
+		case <-s.end:
+			return
+		case <-s.syncTrigger:
+		case <-tick:
+		}
+		if err := s.SyncModel(); err != nil {
+			s.log().Debug("background sync failed", "err", err)
+		}
+
+		// Coalesce modifications until the interval has passed
+		select {
+		case <-s.end:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
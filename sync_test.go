@@ -0,0 +1,26 @@
+package goui
+
+import (
+	"testing"
+)
+
+func TestSyncNotifier(t *testing.T) {
+	m := &MyModel{Details: &DetailsModel{}}
+	w := NewWindow("/", &tsRemote{}, m)
+	if _, err := marshalDiff(m, w.notifier, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w.syncTrigger:
+		t.Fatal("The model is not dirty")
+	default:
+	}
+
+	// Modifying a child notifies the window
+	m.Details.ModelDirty()
+	select {
+	case <-w.syncTrigger:
+	default:
+		t.Fatal("The window has not been notified")
+	}
+}
@@ -0,0 +1,213 @@
+package goui
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// GenerateTypeScript creates a TypeScript declaration file (.d.ts) for the
+// JavaScript API generated by GenerateJSCode.
+// It declares the methods of `remote` with parameter and return types derived
+// from the Go types and declares the type of `go.data` as the type of `model`.
+// Struct fields are named as they are synced, i.e. json tags are honoured.
+// Since Go does not retain parameter names, parameters are named p1, p2 and so on.
+// `model` can be nil if the window has no model. A typed nil pointer is sufficient
+// for both, `remote` and `model`.
+func GenerateTypeScript(remote interface{}, model interface{}) string {
+	g := &tsGenerator{names: make(map[reflect.Type]string), used: make(map[string]bool)}
+
+	var api strings.Builder
+	data := "null"
+	if model != nil {
+		data = g.typeName(reflect.TypeOf(model))
+	}
+	api.WriteString("export interface GoAPI {\n")
+	api.WriteString("    data: " + data + ";\n")
+	api.WriteString("    RemoteError: typeof RemoteError;\n")
+	api.WriteString("    addEventListener(name: string, cb: (ev: any) => void): void;\n")
+	api.WriteString("    removeEventListener(name: string, cb: (ev: any) => void): void;\n")
+	api.WriteString("    set(obj: { _id: number }, field: string, value: any): Promise<void>;\n")
+	api.WriteString("    splice(obj: { _id: number }, field: string, start: number, deleteCount: number, ...items: any[]): Promise<void>;\n")
+	api.WriteString("    fetch(obj: { _id: number }, field: string): Promise<any>;\n")
+	api.WriteString("    undo(): Promise<void>;\n")
+	api.WriteString("    redo(): Promise<void>;\n")
+	api.WriteString("    connect(): Promise<void>;\n")
+	t := reflect.TypeOf(remote)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		var params []string
+		for i := firstParam(m); i < m.Type.NumIn(); i++ {
+			params = append(params, fmt.Sprintf("p%v: %v", len(params)+1, g.typeName(m.Type.In(i))))
+		}
+		params = append(params, "signal?: AbortSignal")
+		api.WriteString(fmt.Sprintf("    %v(%v): Promise<%v>;\n", m.Name, strings.Join(params, ", "), g.resultType(m.Type)))
+	}
+	api.WriteString("}\n")
+
+	var b strings.Builder
+	b.WriteString("// Code generated by goui. DO NOT EDIT.\n\n")
+	for _, decl := range g.decls {
+		b.WriteString(decl)
+		b.WriteString("\n")
+	}
+	b.WriteString("export declare class RemoteError extends Error {\n")
+	b.WriteString("    code?: string;\n")
+	b.WriteString("    data?: any;\n")
+	b.WriteString("}\n\n")
+	b.WriteString(api.String())
+	b.WriteString("\ndeclare global {\n")
+	b.WriteString("    const go: GoAPI;\n")
+	b.WriteString("    interface Window {\n")
+	b.WriteString("        go: GoAPI;\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// tsGenerator collects the interface declarations of named struct types.
+type tsGenerator struct {
+	// names maps struct types to the names of their interface declarations
+	names map[reflect.Type]string
+	used  map[string]bool
+	decls []string
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// resultType returns the type of the value a promise is resolved with.
+func (g *tsGenerator) resultType(f reflect.Type) string {
+	var results []string
+	for i := 0; i < f.NumOut(); i++ {
+		t := f.Out(i)
+		if i+1 == f.NumOut() && t.Kind() == reflect.Interface && t.Implements(errorInterface) {
+			break
+		}
+		results = append(results, g.typeName(t))
+	}
+	switch len(results) {
+	case 0:
+		return "void"
+	case 1:
+		return results[0]
+	}
+	return "[" + strings.Join(results, ", ") + "]"
+}
+
+// typeName returns the TypeScript type of the JSON encoding of t.
+func (g *tsGenerator) typeName(t reflect.Type) string {
+	if t == timeType {
+		return "string"
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		return "any"
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return "string"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		if t == numberType {
+			return "number"
+		}
+		return "string"
+	case reflect.Interface:
+		return "any"
+	case reflect.Ptr:
+		return g.typeName(t.Elem()) + " | null"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64 encoded
+			return "string"
+		}
+		return g.elemName(t.Elem()) + "[] | null"
+	case reflect.Array:
+		return g.elemName(t.Elem()) + "[]"
+	case reflect.Map:
+		return "{ [key: string]: " + g.typeName(t.Elem()) + " } | null"
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structBody(t, "")
+		}
+		return g.declare(t)
+	}
+	return "any"
+}
+
+// elemName returns the type of an array element.
+func (g *tsGenerator) elemName(t reflect.Type) string {
+	name := g.typeName(t)
+	if strings.Contains(name, "|") {
+		return "(" + name + ")"
+	}
+	return name
+}
+
+// declare adds an interface declaration for the named struct type t
+// and returns the name of the interface.
+func (g *tsGenerator) declare(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+	// Types of different packages can have the same name
+	name := t.Name()
+	for i := 2; g.used[name]; i++ {
+		name = fmt.Sprintf("%v%v", t.Name(), i)
+	}
+	g.used[name] = true
+	g.names[t] = name
+	// Reserve the position, because the fields might declare more interfaces
+	index := len(g.decls)
+	g.decls = append(g.decls, "")
+	g.decls[index] = "export interface " + name + " " + g.structBody(t, "") + "\n"
+	return name
+}
+
+// structBody returns the members of the JSON object a struct is encoded as.
+func (g *tsGenerator) structBody(t reflect.Type, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	if reflect.PtrTo(t).Implements(modelIfaceType) {
+		b.WriteString(indent + "    _id: number;\n")
+	}
+	for _, f := range cachedTypeFields(t).list {
+		if f.lazy {
+			// Lazy fields are not part of go.data
+			continue
+		}
+		ft := typeByIndex(t, f.index)
+		name := g.typeName(ft)
+		if f.quoted {
+			name = "string"
+		}
+		optional := ""
+		if f.omitEmpty {
+			optional = "?"
+		}
+		readonly := ""
+		if f.readonly {
+			readonly = "readonly "
+		}
+		b.WriteString(fmt.Sprintf("%v    %v%v%v: %v;\n", indent, readonly, tsPropertyName(f.name), optional, name))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// tsPropertyName quotes property names that are no valid identifiers.
+func tsPropertyName(name string) string {
+	for i, c := range name {
+		if c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return fmt.Sprintf("%q", name)
+	}
+	return name
+}
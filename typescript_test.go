@@ -0,0 +1,55 @@
+package goui
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tsItem struct {
+	Model
+	Name    string    `json:"name"`
+	Tags    []string  `json:"tags,omitempty"`
+	Created time.Time `json:"created"`
+	Parent  *tsItem   `json:"-"`
+}
+
+type tsModel struct {
+	Model
+	Items []*tsItem
+	Count int64 `json:",string"`
+	Attrs map[string]float64
+}
+
+type tsRemote struct{}
+
+func (r *tsRemote) Add(ctx context.Context, name string, tags []string) (*tsItem, error) {
+	return nil, nil
+}
+
+func (r *tsRemote) Split(i int) (int, int) {
+	return i / 2, i - i/2
+}
+
+func (r *tsRemote) Clear() error {
+	return nil
+}
+
+func TestGenerateTypeScript(t *testing.T) {
+	code := GenerateTypeScript(&tsRemote{}, (*tsModel)(nil))
+	expected := []string{
+		"export interface tsModel {\n    _id: number;\n    Items: (tsItem | null)[] | null;\n    Count: string;\n    Attrs: { [key: string]: number } | null;\n}",
+		"export interface tsItem {\n    _id: number;\n    name: string;\n    tags?: string[] | null;\n    created: string;\n}",
+		"    data: tsModel | null;",
+		"    Add(p1: string, p2: string[] | null, signal?: AbortSignal): Promise<tsItem | null>;",
+		"    Clear(signal?: AbortSignal): Promise<void>;",
+		"    Split(p1: number, signal?: AbortSignal): Promise<[number, number]>;",
+		"    const go: GoAPI;",
+	}
+	for _, e := range expected {
+		if !strings.Contains(code, e) {
+			t.Fatalf("Missing %q in:\n%v", e, code)
+		}
+	}
+}
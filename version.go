@@ -0,0 +1,139 @@
+package goui
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"golang.org/x/net/websocket"
+)
+
+// ModelVersion returns the version of the latest model message sent to the browser
+// and the latest version the browser has acknowledged.
+// Each model message carries a version, which increases by one with each message.
+// The browser acknowledges each message after applying it. If the browser detects
+// a missing version, it requests the entire model, which the window sends with the next version.
+// When the browser reconnects, the window sends the messages the browser has missed again
+// if it still has them, or the entire model otherwise.
+func (s *Window) ModelVersion() (sent int, acknowledged int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.version, s.ackedVersion
+}
+
+// ack records the version acknowledged by the browser.
+func (s *Window) ack(inv *invocation) {
+	if len(inv.Message) != 1 {
+		return
+	}
+	var version int
+	if err := json.Unmarshal(inv.Message[0], &version); err != nil {
+		return
+	}
+	s.lock.Lock()
+	if version > s.ackedVersion && version <= s.version {
+		s.ackedVersion = version
+		s.forget()
+	}
+	s.lock.Unlock()
+}
+
+// appendVersion adds the version to the model message `data`.
+// `full` tells the browser that the message contains the entire model.
+func appendVersion(data []byte, version int, full bool) []byte {
+	data = append(data[:len(data)-1], `,"ver":`...)
+	data = strconv.AppendInt(data, int64(version), 10)
+	if full {
+		data = append(data, `,"full":true`...)
+	}
+	return append(data, '}')
+}
+
+// maxUnackedBytes limits the size of the model messages kept for replay.
+// If the browser falls further behind, it receives the entire model after a reconnect.
+const maxUnackedBytes = 4 << 20
+
+// modelMessage is a model message as sent to the browser.
+type modelMessage struct {
+	version int
+	data    []byte
+	// full is true if the message contains the entire model
+	full bool
+	// binary is true if byte slices are encoded for MessagePack
+	binary bool
+}
+
+// remember keeps a model message until the browser acknowledges it.
+// The caller must hold the lock of the window.
+func (s *Window) remember(msg modelMessage) {
+	if msg.full {
+		// Older messages are obsolete
+		s.unacked = nil
+		s.unackedBytes = 0
+	}
+	s.unacked = append(s.unacked, msg)
+	s.unackedBytes += len(msg.data)
+	for s.unackedBytes > maxUnackedBytes && len(s.unacked) > 0 {
+		s.unackedBytes -= len(s.unacked[0].data)
+		s.unacked = s.unacked[1:]
+	}
+}
+
+// forget drops the model messages up to the acknowledged version.
+// The caller must hold the lock of the window.
+func (s *Window) forget() {
+	for len(s.unacked) > 0 && s.unacked[0].version <= s.ackedVersion {
+		s.unackedBytes -= len(s.unacked[0].data)
+		s.unacked = s.unacked[1:]
+	}
+}
+
+// prepareReplay decides how a reconnected browser catches up.
+// The browser passes the version it has applied in the URL of the websocket.
+// If the window still has all later model messages, they are sent again.
+// Otherwise, the browser receives the entire model.
+// The caller must hold the lock of the window.
+func (s *Window) prepareReplay(conn *websocket.Conn) {
+	s.replay = nil
+	s.fullSync = true
+	if conn.Request() == nil {
+		return
+	}
+	version, err := strconv.Atoi(conn.Request().URL.Query().Get("ver"))
+	if err != nil || version <= 0 || version > s.version {
+		return
+	}
+	// The browser has applied `version`, hence it has applied all previous ones as well
+	if version > s.ackedVersion {
+		s.ackedVersion = version
+		s.forget()
+	}
+	if version < s.version && (len(s.unacked) == 0 || s.unacked[0].version != version+1) {
+		// The missing messages are gone
+		return
+	}
+	for _, msg := range s.unacked {
+		if msg.binary != isBinary(conn) {
+			return
+		}
+	}
+	s.replay = s.unacked
+	s.fullSync = false
+}
+
+// replayModel sends the model messages a reconnected browser has missed
+// and syncs the model afterwards.
+func (s *Window) replayModel() {
+	s.modelLock.Lock()
+	defer s.modelLock.Unlock()
+	s.lock.Lock()
+	for _, msg := range s.replay {
+		s.log().Debug("replaying model", "conn", s.connID, "version", msg.version)
+		if err := s.send(s.conn, msg.data, true); err != nil {
+			s.log().Warn("websocket failed while replaying model", "conn", s.connID, "err", err)
+			break
+		}
+	}
+	s.replay = nil
+	s.lock.Unlock()
+	s.syncModel()
+}